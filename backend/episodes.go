@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// episode_number orders episodes by season, then episode.
+type episode_number struct {
+	season, episode int
+}
+
+func (en episode_number) before(other episode_number) bool {
+	return en.season < other.season || (en.season == other.season && en.episode < other.episode)
+}
+
+// episode_numbers returns the first and last episode of a release, which
+// differ for multi-episode files.
+func episode_numbers(release ReleaseInfo) (first, last episode_number) {
+	return episode_number{release.Season, release.Episode()}, episode_number{release.Season, release.LastEpisode()}
+}
+
+// NextEpisode returns the video to play after file. If the name of file says
+// which episode it is, that is the following episode of the same show in its
+// folder. Otherwise it is the next video in the folder in natural order. When
+// file is the last one in its folder, the next episode is looked for in the
+// folder of the following season, or of the following episode for releases
+// with a folder per episode. The empty string means there is no next
+// episode.
+func NextEpisode(file string) string {
+	dir := filepath.Dir(file)
+	name := filepath.Base(file)
+	videos := list_videos(read_dir_cached(dir))
+
+	release := ParseRelease(name)
+	next := ""
+	if release.IsEpisode() {
+		_, current := episode_numbers(release)
+		var next_number episode_number
+		for _, video := range videos {
+			video_release := ParseRelease(video)
+			number, _ := episode_numbers(video_release)
+			if video_release.IsEpisode() && same_show(release, video_release) && current.before(number) &&
+				(next == "" || number.before(next_number)) {
+				next, next_number = video, number
+			}
+		}
+	} else {
+		for _, video := range videos {
+			if NaturalLess(name, video) && (next == "" || NaturalLess(video, next)) {
+				next = video
+			}
+		}
+	}
+	if next != "" {
+		return filepath.Join(dir, next)
+	}
+
+	dir_release := ParseRelease(filepath.Base(dir))
+	next_dir := ""
+	if dir_release.IsEpisode() {
+		_, current := episode_numbers(dir_release)
+		next_dir = next_sibling_dir(dir, dir_release, func(sibling ReleaseInfo) (episode_number, bool) {
+			first, _ := episode_numbers(sibling)
+			return first, sibling.IsEpisode() && current.before(first)
+		})
+	} else if dir_release.HasSeason {
+		season := dir_release.Season
+		if release.HasSeason {
+			season = release.Season
+		}
+		next_dir = next_sibling_dir(dir, dir_release, func(sibling ReleaseInfo) (episode_number, bool) {
+			return episode_number{sibling.Season, 0}, sibling.HasSeason && !sibling.IsEpisode() && sibling.Season > season
+		})
+	}
+	if next_dir != "" {
+		return first_episode(next_dir)
+	}
+	return ""
+}
+
+// next_sibling_dir returns the folder next to dir, of the same show as
+// dir_release, that comes first of those accepted by follows.
+func next_sibling_dir(dir string, dir_release ReleaseInfo, follows func(ReleaseInfo) (episode_number, bool)) string {
+	parent := filepath.Dir(dir)
+	next := ""
+	var next_number episode_number
+	for _, entry := range read_dir_cached(parent) {
+		if !entry.IsDir() {
+			continue
+		}
+		sibling := ParseRelease(entry.Name())
+		number, ok := follows(sibling)
+		if ok && same_show(dir_release, sibling) && (next == "" || number.before(next_number)) {
+			next, next_number = entry.Name(), number
+		}
+	}
+	if next == "" {
+		return ""
+	}
+	return filepath.Join(parent, next)
+}
+
+// first_episode returns the episode with the lowest number in dir, or the
+// first video in natural order if none are numbered.
+func first_episode(dir string) string {
+	videos := list_videos(read_dir_cached(dir))
+	if len(videos) == 0 {
+		return ""
+	}
+	first := videos[0]
+	var first_number episode_number
+	numbered := false
+	for _, video := range videos {
+		release := ParseRelease(video)
+		number, _ := episode_numbers(release)
+		if release.IsEpisode() && (!numbered || number.before(first_number)) {
+			first, first_number, numbered = video, number, true
+		} else if !numbered && NaturalLess(video, first) {
+			first = video
+		}
+	}
+	return filepath.Join(dir, first)
+}
+
+// same_show reports whether two releases are of the same show, as far as
+// their titles tell. Names like Season 2 have no title, and go with any show.
+func same_show(a, b ReleaseInfo) bool {
+	return a.Title == "" || b.Title == "" || strings.EqualFold(a.Title, b.Title)
+}
+
+// list_videos returns the names of the videos among entries.
+func list_videos(entries []os.FileInfo) (videos []string) {
+	for _, entry := range entries {
+		if !entry.IsDir() && IsVideo(entry.Name()) {
+			videos = append(videos, entry.Name())
+		}
+	}
+	return
+}
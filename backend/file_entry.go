@@ -23,6 +23,13 @@ type FileEntry struct {
 	IsDir, IsAccessible, IsVideo bool
 	Parent                       *FileEntry
 	ElementInParent              *list.Element
+
+	// From when the directory was read, see file_info.go
+	info        os.FileInfo
+	link_target string
+
+	release *ReleaseInfo
+	sorted  SortMode
 }
 
 func CreateDirEntry(abspath string) (*FileEntry, error) {
@@ -47,6 +54,7 @@ func (fe *FileEntry) ValidateContents() error {
 		if err != nil {
 			return err
 		}
+		fe.SortContents(DefaultSortMode())
 
 		fe.contents_read = true
 	}
@@ -68,6 +76,7 @@ func (file_entry *FileEntry) walk_func() filepath.WalkFunc {
 			IsVideo:      IsVideo(filepath.Base(dir)),
 			Parent:       fe,
 		}
+		new_file.set_info(fi)
 		new_file.ElementInParent = fe.Contents.PushBack(&new_file)
 		if fi.IsDir() {
 			return filepath.SkipDir
@@ -76,6 +85,16 @@ func (file_entry *FileEntry) walk_func() filepath.WalkFunc {
 	}
 }
 
+// Release returns what the name of the entry says about the release, like
+// the show, season and episode. It is parsed once, when first asked for.
+func (fe *FileEntry) Release() *ReleaseInfo {
+	if fe.release == nil {
+		release := ParseRelease(fe.Name)
+		fe.release = &release
+	}
+	return fe.release
+}
+
 func (fe *FileEntry) GetElementInParent() (eip *list.Element) {
 	fe.ValidateParent()
 	return fe.ElementInParent
@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Owner names by user id, as looking them up reads the user database
+var (
+	owner_names      = make(map[int]string)
+	owner_names_lock sync.Mutex
+)
+
+// set_info keeps what reading the directory of the entry said about its
+// file. Symlinks are described as links, like os.Lstat does, and their
+// targets are read right away.
+func (fe *FileEntry) set_info(fi os.FileInfo) {
+	fe.info = fi
+	if fi != nil && fi.Mode()&os.ModeSymlink != 0 {
+		fe.link_target, _ = os.Readlink(fe.AbsPath)
+	}
+}
+
+// SetInfo keeps what is known about the file of an entry that wasn't found
+// by reading its directory, like those of playlists.
+func (fe *FileEntry) SetInfo(fi os.FileInfo) {
+	fe.set_info(fi)
+}
+
+// Info returns what was known about the file when its directory was read, or
+// nil if nothing is.
+func (fe *FileEntry) Info() os.FileInfo {
+	return fe.info
+}
+
+// Size returns the size of the file in bytes. It is 0 for directories, as
+// what their size means differs between systems, and for files whose size
+// isn't known.
+func (fe *FileEntry) Size() int64 {
+	if fe.info == nil || fe.IsDir {
+		return 0
+	}
+	return fe.info.Size()
+}
+
+// ModTime returns when the file was last modified, or the zero time if it
+// isn't known.
+func (fe *FileEntry) ModTime() (t time.Time) {
+	if fe.info != nil {
+		t = fe.info.ModTime()
+	}
+	return
+}
+
+// ChangeTime returns when the inode of the file last changed, which it also
+// does when the file is renamed or moved. On systems that don't keep track
+// of it, it is the modification time.
+func (fe *FileEntry) ChangeTime() (t time.Time) {
+	if fe.info != nil {
+		t = file_change_time(fe.info)
+	}
+	return
+}
+
+// Mode returns the mode and permission bits of the file, or 0 if they
+// aren't known.
+func (fe *FileEntry) Mode() os.FileMode {
+	if fe.info == nil {
+		return 0
+	}
+	return fe.info.Mode()
+}
+
+func (fe *FileEntry) IsSymlink() bool {
+	return fe.Mode()&os.ModeSymlink != 0
+}
+
+// LinkTarget returns what a symlink points to, as written in the link, or
+// the empty string for anything else.
+func (fe *FileEntry) LinkTarget() string {
+	return fe.link_target
+}
+
+// Owner returns the user and group ids of the file. ok is false on systems
+// without them, or if they aren't known.
+func (fe *FileEntry) Owner() (uid, gid int, ok bool) {
+	if fe.info == nil {
+		return -1, -1, false
+	}
+	return file_owner(fe.info)
+}
+
+// OwnerName returns the name of the user owning the file, or its user id if
+// the user has no name. It is the empty string if the owner isn't known.
+func (fe *FileEntry) OwnerName() string {
+	uid, _, ok := fe.Owner()
+	if !ok {
+		return ""
+	}
+
+	owner_names_lock.Lock()
+	defer owner_names_lock.Unlock()
+	name, ok := owner_names[uid]
+	if !ok {
+		name = strconv.Itoa(uid)
+		if owner, err := user.LookupId(name); err == nil {
+			name = owner.Username
+		}
+		owner_names[uid] = name
+	}
+	return name
+}
+
+// FileID returns the device and inode numbers of the file, which are the
+// same for hard links to the same file. ok is false on systems without them,
+// or if they aren't known.
+func (fe *FileEntry) FileID() (dev, ino uint64, ok bool) {
+	if fe.info == nil {
+		return 0, 0, false
+	}
+	return file_id(fe.info)
+}
+
+// SameFile reports whether two entries are the same file, like hard links
+// to it, as far as is known.
+func (fe *FileEntry) SameFile(other *FileEntry) bool {
+	if fe.info == nil || other.info == nil {
+		return false
+	}
+	return os.SameFile(fe.info, other.info)
+}
+
+// Extension returns the extension of the file in lower case, or the empty
+// string for directories.
+func (fe *FileEntry) Extension() string {
+	if fe.IsDir {
+		return ""
+	}
+	return strings.ToLower(filepath.Ext(fe.Name))
+}
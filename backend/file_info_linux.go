@@ -0,0 +1,30 @@
+package backend
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// file_change_time returns when the inode of a file last changed, or when it was
+// last modified if the system doesn't say.
+func file_change_time(fi os.FileInfo) time.Time {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+	}
+	return fi.ModTime()
+}
+
+func file_owner(fi os.FileInfo) (uid, gid int, ok bool) {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid), true
+	}
+	return -1, -1, false
+}
+
+func file_id(fi os.FileInfo) (dev, ino uint64, ok bool) {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), uint64(stat.Ino), true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package backend
+
+import (
+	"os"
+	"time"
+)
+
+// file_change_time returns when a file was last modified, as the change time of
+// its inode isn't known on this system.
+func file_change_time(fi os.FileInfo) time.Time {
+	return fi.ModTime()
+}
+
+func file_owner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return -1, -1, false
+}
+
+func file_id(fi os.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,339 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chrigrah/nextplz/util"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	media_info_timeout = 30 * time.Second
+	// Files waiting to be probed. The ones asked for first are forgotten
+	// beyond this, as they have most likely been scrolled past.
+	media_info_max_pending = 256
+	// Results are saved together, at most this often
+	media_info_save_delay = 5 * time.Second
+)
+
+var (
+	// FFProbe is the ffprobe executable media info is read with. Media info
+	// is turned off if it is empty or can't be found.
+	FFProbe string = "ffprobe"
+	// MediaInfoWorkers is how many files are probed at the same time.
+	MediaInfoWorkers int = 2
+
+	// GlobalMediaInfo reads media info of the videos in the listings.
+	GlobalMediaInfo *MediaInfoStore = NewMediaInfoStore("", nil)
+)
+
+// MediaInfo is what ffprobe says about a video.
+type MediaInfo struct {
+	Duration   int          `json:"duration,omitempty"` // Seconds
+	Width      int          `json:"width,omitempty"`
+	Height     int          `json:"height,omitempty"`
+	VideoCodec string       `json:"video_codec,omitempty"`
+	Audio      []MediaTrack `json:"audio,omitempty"`
+	Subtitles  []MediaTrack `json:"subtitles,omitempty"` // Embedded in the file
+}
+
+type MediaTrack struct {
+	Codec    string `json:"codec,omitempty"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+// Summary describes the media info on one line, like
+// 1:23:45 1080p h264 aac eng,swe subs eng,fin.
+func (mi *MediaInfo) Summary() string {
+	var parts []string
+	if mi.Duration > 0 {
+		parts = append(parts, util.FormatSeconds(mi.Duration))
+	}
+	if mi.Height > 0 {
+		parts = append(parts, fmt.Sprintf("%dp", mi.Height))
+	}
+	if mi.VideoCodec != "" {
+		parts = append(parts, mi.VideoCodec)
+	}
+	if len(mi.Audio) > 0 {
+		parts = append(parts, mi.Audio[0].Codec)
+		if languages := track_languages(mi.Audio); languages != "" {
+			parts = append(parts, languages)
+		}
+	}
+	if len(mi.Subtitles) > 0 {
+		languages := track_languages(mi.Subtitles)
+		if languages == "" {
+			languages = strconv.Itoa(len(mi.Subtitles))
+		}
+		parts = append(parts, "subs "+languages)
+	}
+	return strings.Join(parts, " ")
+}
+
+// track_languages lists the languages of tracks, each once.
+func track_languages(tracks []MediaTrack) string {
+	var languages []string
+	seen := make(map[string]bool)
+	for _, track := range tracks {
+		if track.Language != "" && track.Language != "und" && !seen[track.Language] {
+			languages = append(languages, track.Language)
+			seen[track.Language] = true
+		}
+	}
+	return strings.Join(languages, ",")
+}
+
+// MediaInfoStore probes videos with ffprobe in the background and remembers
+// the results in a JSON file. Like in the StateStore, a result belongs to
+// the version of the file with the same size and modification time.
+type MediaInfoStore struct {
+	path        string
+	update_chan chan int
+	ffprobe     string // Empty if media info is off
+
+	lock       sync.Mutex
+	wake       *sync.Cond
+	files      map[string]*media_info_entry
+	pending    []string // Asked for last, probed first
+	queued     map[string]bool
+	workers    int
+	saving     bool
+	generation uint
+
+	save_lock sync.Mutex // Held while the file is written
+}
+
+type media_info_entry struct {
+	Size    int64      `json:"size"`
+	ModTime int64      `json:"mtime"`
+	Info    *MediaInfo `json:"info,omitempty"`
+	Error   string     `json:"error,omitempty"` // Why ffprobe couldn't read the file
+}
+
+func DefaultMediaInfoPath() string {
+	return filepath.Join(util.ConfigDir(), "media_info.json")
+}
+
+// NewMediaInfoStore creates an empty store that saves to path, or nowhere if
+// path is empty, and announces new results on update_chan. Files are probed
+// with FFProbe if it can be found, and there is an update_chan.
+func NewMediaInfoStore(path string, update_chan chan int) *MediaInfoStore {
+	mis := &MediaInfoStore{
+		path:        path,
+		update_chan: update_chan,
+		files:       make(map[string]*media_info_entry),
+		queued:      make(map[string]bool),
+	}
+	mis.wake = sync.NewCond(&mis.lock)
+	if FFProbe != "" && update_chan != nil {
+		mis.ffprobe, _ = exec.LookPath(FFProbe)
+	}
+	return mis
+}
+
+// LoadMediaInfoStore loads the store saved at path, see NewMediaInfoStore. A
+// missing file results in an empty store. If the file can't be read, an
+// empty store is returned along with the error.
+func LoadMediaInfoStore(path string, update_chan chan int) (*MediaInfoStore, error) {
+	mis := NewMediaInfoStore(path, update_chan)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return mis, nil
+	} else if err != nil {
+		return mis, err
+	}
+	if err = json.Unmarshal(data, &mis.files); err != nil {
+		mis.files = make(map[string]*media_info_entry)
+		return mis, err
+	}
+	return mis, nil
+}
+
+// Available reports whether files are probed, which they aren't if ffprobe
+// can't be found. Results saved earlier are still at hand.
+func (mis *MediaInfoStore) Available() bool {
+	return mis.ffprobe != ""
+}
+
+// Generation changes every time a result comes in, so that cached
+// presentations of media info can be thrown away.
+func (mis *MediaInfoStore) Generation() uint {
+	mis.lock.Lock()
+	defer mis.lock.Unlock()
+	return mis.generation
+}
+
+// Get returns the media info of the video of entry. If it isn't known yet,
+// the video is queued to be probed and ok is false. The update channel is
+// poked when the result is in.
+func (mis *MediaInfoStore) Get(entry *FileEntry) (info *MediaInfo, ok bool) {
+	if entry.IsDir || !entry.IsVideo || strings.HasSuffix(entry.Name, ".rar") {
+		return nil, false
+	}
+	size, mtime, err := file_identity(entry.AbsPath, entry.Info())
+	if err != nil {
+		return nil, false
+	}
+
+	mis.lock.Lock()
+	defer mis.lock.Unlock()
+	if known, ok := mis.files[entry.AbsPath]; ok && known.Size == size && known.ModTime == mtime {
+		return known.Info, known.Info != nil
+	}
+	mis.queue(entry.AbsPath)
+	return nil, false
+}
+
+// queue adds file to the files to probe. The lock must be held.
+func (mis *MediaInfoStore) queue(file string) {
+	if mis.ffprobe == "" || mis.queued[file] {
+		return
+	}
+	mis.pending = append(mis.pending, file)
+	mis.queued[file] = true
+	if len(mis.pending) > media_info_max_pending {
+		delete(mis.queued, mis.pending[0])
+		mis.pending = mis.pending[1:]
+	}
+	if mis.workers < MediaInfoWorkers {
+		mis.workers++
+		go mis.work()
+	}
+	mis.wake.Signal()
+}
+
+func (mis *MediaInfoStore) work() {
+	for {
+		mis.lock.Lock()
+		for len(mis.pending) == 0 {
+			mis.wake.Wait()
+		}
+		file := mis.pending[len(mis.pending)-1]
+		mis.pending = mis.pending[:len(mis.pending)-1]
+		mis.lock.Unlock()
+
+		// The version of the file that gets probed
+		size, mtime, err := file_identity(file, nil)
+		var info *MediaInfo
+		if err == nil {
+			info, err = mis.probe(file)
+		}
+
+		mis.lock.Lock()
+		delete(mis.queued, file)
+		known := &media_info_entry{Size: size, ModTime: mtime, Info: info}
+		if err != nil {
+			known.Error = err.Error()
+		}
+		mis.files[file] = known
+		mis.generation++
+		if !mis.saving && mis.path != "" {
+			mis.saving = true
+			time.AfterFunc(media_info_save_delay, func() { mis.Save() })
+		}
+		mis.lock.Unlock()
+
+		select {
+		case mis.update_chan <- 1:
+		default: // An update is already pending
+		}
+	}
+}
+
+type ffprobe_output struct {
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType   string `json:"codec_type"`
+		CodecName   string `json:"codec_name"`
+		Width       int    `json:"width"`
+		Height      int    `json:"height"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"` // Cover art
+		} `json:"disposition"`
+		Tags struct {
+			Language string `json:"language"`
+			Title    string `json:"title"`
+		} `json:"tags"`
+	} `json:"streams"`
+}
+
+func (mis *MediaInfoStore) probe(file string) (*MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), media_info_timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, mis.ffprobe, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", file)
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("ffprobe: %s", strings.SplitN(message, "\n", 2)[0])
+		}
+		return nil, fmt.Errorf("ffprobe: %s", err)
+	}
+
+	var output ffprobe_output
+	if err = json.Unmarshal(data, &output); err != nil {
+		return nil, fmt.Errorf("ffprobe: %s", err)
+	}
+	info := &MediaInfo{}
+	if duration, err := strconv.ParseFloat(output.Format.Duration, 64); err == nil {
+		info.Duration = int(duration + 0.5)
+	}
+	for _, stream := range output.Streams {
+		track := MediaTrack{Codec: stream.CodecName, Language: stream.Tags.Language, Title: stream.Tags.Title}
+		switch stream.CodecType {
+		case "video":
+			if info.VideoCodec == "" && stream.Disposition.AttachedPic == 0 {
+				info.VideoCodec, info.Width, info.Height = stream.CodecName, stream.Width, stream.Height
+			}
+		case "audio":
+			info.Audio = append(info.Audio, track)
+		case "subtitle":
+			info.Subtitles = append(info.Subtitles, track)
+		}
+	}
+	return info, nil
+}
+
+// Save writes the store to a temporary file which then replaces the old one.
+// Results are saved a little while after they come in, so Save is meant to
+// be called once more on exit.
+func (mis *MediaInfoStore) Save() error {
+	mis.save_lock.Lock()
+	defer mis.save_lock.Unlock()
+
+	mis.lock.Lock()
+	mis.saving = false
+	if mis.path == "" {
+		mis.lock.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(mis.files)
+	mis.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(mis.path), 0755); err != nil {
+		return err
+	}
+	tmp_path := mis.path + ".tmp"
+	if err = ioutil.WriteFile(tmp_path, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp_path, mis.path)
+}
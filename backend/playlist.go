@@ -0,0 +1,249 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	PlaylistExtensions = []string{".m3u", ".m3u8", ".pls", ".xspf"}
+
+	pls_file_re = regexp.MustCompile(`^(?i)File(\d+)=(.*)$`)
+)
+
+func IsPlaylist(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, playlist_ext := range PlaylistExtensions {
+		if ext == playlist_ext {
+			return true
+		}
+	}
+	return false
+}
+
+// WritePlaylist writes files to a playlist at path, in the format its
+// extension says. With relative set, files are written relative to the
+// directory of the playlist where possible.
+func WritePlaylist(path string, files []string, relative bool) error {
+	write := playlist_writer(path)
+	if write == nil {
+		return fmt.Errorf("Unknown playlist format: %s, use one of %s", path, strings.Join(PlaylistExtensions, ", "))
+	}
+	abs_path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]string, len(files))
+	for i, file := range files {
+		entries[i] = file
+		if relative {
+			if rel, err := filepath.Rel(filepath.Dir(abs_path), file); err == nil {
+				entries[i] = rel
+			}
+		}
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(out, entries)
+	if close_err := out.Close(); err == nil {
+		err = close_err
+	}
+	return err
+}
+
+func playlist_writer(path string) func(io.Writer, []string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		return write_m3u
+	case ".pls":
+		return write_pls
+	case ".xspf":
+		return write_xspf
+	}
+	return nil
+}
+
+func write_m3u(out io.Writer, entries []string) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "#EXTINF:-1,%s\n", playlist_title(entry))
+		fmt.Fprintln(w, entry)
+	}
+	return w.Flush()
+}
+
+func write_pls(out io.Writer, entries []string) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "[playlist]")
+	for i, entry := range entries {
+		fmt.Fprintf(w, "File%d=%s\n", i+1, entry)
+		fmt.Fprintf(w, "Title%d=%s\n", i+1, playlist_title(entry))
+	}
+	fmt.Fprintf(w, "NumberOfEntries=%d\n", len(entries))
+	fmt.Fprintln(w, "Version=2")
+	return w.Flush()
+}
+
+type xspf_playlist struct {
+	XMLName xml.Name     `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string       `xml:"version,attr"`
+	Tracks  []xspf_track `xml:"trackList>track"`
+}
+
+type xspf_track struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+}
+
+func write_xspf(out io.Writer, entries []string) error {
+	playlist := xspf_playlist{Version: "1"}
+	for _, entry := range entries {
+		location := url.URL{Path: filepath.ToSlash(entry)}
+		if filepath.IsAbs(entry) {
+			location.Scheme = "file"
+			if !strings.HasPrefix(location.Path, "/") {
+				location.Path = "/" + location.Path // C:/... on windows
+			}
+		}
+		playlist.Tracks = append(playlist.Tracks, xspf_track{location.String(), playlist_title(entry)})
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "\t")
+	return encoder.Encode(playlist)
+}
+
+func playlist_title(entry string) string {
+	base := filepath.Base(entry)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// ReadPlaylist returns the absolute paths of the files in the playlist at
+// path. Entries that aren't local files are left out.
+func ReadPlaylist(path string) (files []string, err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var entries []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		entries, err = read_m3u(in)
+	case ".pls":
+		entries, err = read_pls(in)
+	case ".xspf":
+		entries, err = read_xspf(in)
+	default:
+		err = fmt.Errorf("Unknown playlist format: %s", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	for _, entry := range entries {
+		if file, ok := playlist_entry_to_path(entry, dir); ok {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func read_m3u(in io.Reader) (entries []string, err error) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\uFEFF"))
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func read_pls(in io.Reader) ([]string, error) {
+	numbered := make(map[int]string)
+	var numbers []int
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		matches := pls_file_re.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if matches == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(matches[1])
+		if _, seen := numbered[number]; !seen {
+			numbers = append(numbers, number)
+		}
+		numbered[number] = matches[2]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Ints(numbers)
+	entries := make([]string, len(numbers))
+	for i, number := range numbers {
+		entries[i] = numbered[number]
+	}
+	return entries, nil
+}
+
+func read_xspf(in io.Reader) (entries []string, err error) {
+	var playlist xspf_playlist
+	if err = xml.NewDecoder(in).Decode(&playlist); err != nil {
+		return nil, err
+	}
+	for _, track := range playlist.Tracks {
+		location, err := url.Parse(strings.TrimSpace(track.Location))
+		if err != nil || (location.Scheme != "" && location.Scheme != "file") {
+			continue
+		}
+		path := location.Path
+		if location.Scheme == "file" && len(path) > 2 && path[2] == ':' {
+			path = path[1:] // /C:/... on windows
+		}
+		entries = append(entries, filepath.FromSlash(path))
+	}
+	return entries, nil
+}
+
+// playlist_entry_to_path resolves an entry of a playlist in dir to an
+// absolute path.
+func playlist_entry_to_path(entry, dir string) (string, bool) {
+	if strings.HasPrefix(entry, "file://") {
+		location, err := url.Parse(entry)
+		if err != nil {
+			return "", false
+		}
+		entry = location.Path
+		if len(entry) > 2 && entry[2] == ':' {
+			entry = entry[1:]
+		}
+	} else if strings.Contains(entry, "://") {
+		return "", false // Streams and the like
+	}
+
+	entry = filepath.FromSlash(entry)
+	if !filepath.IsAbs(entry) {
+		entry = filepath.Join(dir, entry)
+	}
+	return filepath.Clean(entry), true
+}
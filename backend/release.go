@@ -0,0 +1,234 @@
+package backend
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ReleaseInfo is what the name of a release, like
+// Show.Name.S02E05.720p.HDTV.x264-GROUP, says about it. Fields the name
+// doesn't mention are left empty.
+type ReleaseInfo struct {
+	Title      string
+	Season     int
+	HasSeason  bool
+	Episodes   []int // More than one for multi-episode files like S01E01E02
+	Year       int
+	Resolution string // Like 720p
+	Source     string // Like HDTV or WEB-DL
+	Codec      string // Like x264
+	Group      string
+}
+
+var (
+	// S01E02, S01E01E02, S01E01-E03, S01E01-03
+	release_se_re = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?(e\d{1,3}(?:[ ._-]?e\d{1,3}|-\d{1,3})*)(?:[^a-z0-9]|$)`)
+	// 1x05, 1x05x06, 1x05-06
+	release_x_re = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3}(?:[x-]\d{2,3})*)(?:[^a-z0-9]|$)`)
+	// S02, Season 2, Series 2
+	release_season_re = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:s|(?:season|series)[ ._-]?)(\d{1,2})(?:[^a-z0-9]|$)`)
+	release_year_re   = regexp.MustCompile(`19\d\d|20\d\d`)
+	release_number_re = regexp.MustCompile(`\d+|-`)
+
+	release_group_re         = regexp.MustCompile(`-([A-Za-z0-9]+)$`)
+	release_leading_group_re = regexp.MustCompile(`^\[([^\]]+)\]\s*`)
+	// Markers with a dash in them, which is not the one before a group
+	release_dashed_markers = []string{"web-dl", "blu-ray"}
+	// Episode ranges like S01E01-03 and 1x05-06, which have no group either
+	release_dashed_episodes_re = regexp.MustCompile(`(?i)^(?:s\d{1,2}e\d{1,3}|\d{1,2}x\d{2,3})(?:-?e?\d{1,3})*$`)
+
+	// Markers and how they are written once parsed
+	release_resolutions = release_markers(
+		"2160p", "2160p", "4k", "2160p", "uhd", "2160p",
+		"1080p", "1080p", "1080i", "1080i", "720p", "720p", "576p", "576p", "480p", "480p")
+	release_sources = release_markers(
+		"web-dl", "WEB-DL", "webdl", "WEB-DL", "webrip", "WEBRip", "web", "WEB",
+		"bluray", "BluRay", "blu-ray", "BluRay", "bdrip", "BDRip", "brrip", "BRRip", "remux", "Remux",
+		"hdtv", "HDTV", "pdtv", "PDTV", "dvdrip", "DVDRip", "dvd", "DVD", "hdrip", "HDRip")
+	release_codecs = release_markers(
+		"x264", "x264", "h264", "H.264", "h.264", "H.264", "avc", "H.264",
+		"x265", "x265", "h265", "H.265", "h.265", "H.265", "hevc", "HEVC",
+		"xvid", "XviD", "divx", "DivX", "av1", "AV1", "vp9", "VP9")
+)
+
+type release_marker struct {
+	re   *regexp.Regexp
+	name string
+}
+
+// release_markers pairs up spellings with how they are written once parsed.
+// Longer spellings come first, so that web-dl isn't taken for web.
+func release_markers(pairs ...string) (markers []release_marker) {
+	for i := 0; i < len(pairs); i += 2 {
+		re := regexp.MustCompile(`(?i)(?:^|[ ._\-\[(])(` + regexp.QuoteMeta(pairs[i]) + `)(?:$|[ ._\-\])])`)
+		markers = append(markers, release_marker{re, pairs[i+1]})
+	}
+	return
+}
+
+// ParseRelease parses the name of a file or folder. Extensions of videos,
+// subtitles and the like are ignored.
+func ParseRelease(name string) (info ReleaseInfo) {
+	name = trim_release_extension(name)
+	// Anything before the first marker is the title
+	title_end := len(name)
+	mark := func(start int) {
+		if start < title_end {
+			title_end = start
+		}
+	}
+
+	if matches := release_leading_group_re.FindStringSubmatch(name); matches != nil {
+		info.Group = matches[1]
+		name = name[len(matches[0]):]
+		title_end = len(name)
+	} else if loc := release_group_re.FindStringSubmatchIndex(name); loc != nil && is_release_group(name, loc[0]) {
+		info.Group = name[loc[2]:loc[3]]
+		name = name[:loc[0]]
+		title_end = len(name)
+	}
+
+	if loc := release_se_re.FindStringSubmatchIndex(name); loc != nil {
+		info.Season, _ = strconv.Atoi(name[loc[2]:loc[3]])
+		info.HasSeason = true
+		info.Episodes = parse_episode_list(name[loc[4]:loc[5]])
+		mark(loc[2] - 1)
+	} else if loc := release_x_re.FindStringSubmatchIndex(name); loc != nil {
+		info.Season, _ = strconv.Atoi(name[loc[2]:loc[3]])
+		info.HasSeason = true
+		info.Episodes = parse_episode_list(name[loc[4]:loc[5]])
+		mark(loc[2])
+	} else if loc := release_season_re.FindStringSubmatchIndex(name); loc != nil {
+		info.Season, _ = strconv.Atoi(name[loc[2]:loc[3]])
+		info.HasSeason = true
+		mark(loc[0])
+	}
+
+	// A year at the very start is part of the title, like 2001 A Space Odyssey.
+	// With several, the last is most likely the year, like in
+	// Blade.Runner.2049.2017.
+	year_at := -1
+	for _, loc := range release_year_re.FindAllStringIndex(name, -1) {
+		if loc[0] > 0 && !is_alphanumeric(name[loc[0]-1]) && (loc[1] == len(name) || !is_alphanumeric(name[loc[1]])) {
+			info.Year, _ = strconv.Atoi(name[loc[0]:loc[1]])
+			year_at = loc[0]
+		}
+	}
+	if year_at >= 0 {
+		mark(year_at)
+	}
+
+	// Titles may contain words like Web, so once the episode or year has been
+	// found, the rest is only looked for after it.
+	search_from := 0
+	if title_end < len(name) {
+		search_from = title_end
+	}
+	info.Resolution = find_release_marker(name, search_from, release_resolutions, mark)
+	info.Source = find_release_marker(name, search_from, release_sources, mark)
+	info.Codec = find_release_marker(name, search_from, release_codecs, mark)
+
+	info.Title = clean_release_title(name[:title_end])
+	return
+}
+
+// IsEpisode reports whether the name says which episode it is.
+func (info *ReleaseInfo) IsEpisode() bool {
+	return len(info.Episodes) > 0
+}
+
+// Episode returns the first episode, or 0 if there is none.
+func (info *ReleaseInfo) Episode() int {
+	if len(info.Episodes) == 0 {
+		return 0
+	}
+	return info.Episodes[0]
+}
+
+// LastEpisode returns the last episode of a multi-episode file, or the one
+// episode of any other.
+func (info *ReleaseInfo) LastEpisode() int {
+	if len(info.Episodes) == 0 {
+		return 0
+	}
+	return info.Episodes[len(info.Episodes)-1]
+}
+
+// parse_episode_list reads the episodes part of S01E01E02 or 1x05-07, where a
+// dash between two numbers means the episodes in between too.
+func parse_episode_list(list string) (episodes []int) {
+	in_range := false
+	for _, token := range release_number_re.FindAllString(list, -1) {
+		if token == "-" {
+			in_range = len(episodes) > 0
+			continue
+		}
+		episode, _ := strconv.Atoi(token)
+		if in_range {
+			for between := episodes[len(episodes)-1] + 1; between < episode; between++ {
+				episodes = append(episodes, between)
+			}
+		}
+		episodes = append(episodes, episode)
+		in_range = false
+	}
+	return
+}
+
+func find_release_marker(name string, from int, markers []release_marker, mark func(int)) string {
+	for _, marker := range markers {
+		if loc := marker.re.FindStringSubmatchIndex(name[from:]); loc != nil {
+			mark(from + loc[2])
+			return marker.name
+		}
+	}
+	return ""
+}
+
+// is_release_group reports whether the dash at i in name is the one before
+// the group, as in x264-GROUP, rather than part of the title or a marker.
+func is_release_group(name string, i int) bool {
+	if !strings.ContainsAny(name[:i], ". _") {
+		return false // Like Spider-Man
+	}
+	token := strings.ToLower(name[strings.LastIndexAny(name[:i], " ._[(")+1:])
+	for _, marker := range release_dashed_markers {
+		if token == marker {
+			return false
+		}
+	}
+	return !release_dashed_episodes_re.MatchString(token)
+}
+
+func is_alphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func trim_release_extension(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return name
+	}
+	if IsSubtitle(name) || ext == ".rar" || ext == ".nfo" || ext == ".mp4" || ext == ".m4v" {
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	for _, video_ext := range VideoExtensions {
+		if strings.EqualFold(ext, video_ext) {
+			return strings.TrimSuffix(name, filepath.Ext(name))
+		}
+	}
+	return name
+}
+
+func clean_release_title(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if r == '.' || r == '_' {
+			return ' '
+		}
+		return r
+	}, title)
+	title = strings.Join(strings.Fields(title), " ")
+	return strings.Trim(title, " -[(")
+}
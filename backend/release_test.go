@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func init() {
+	VideoExtensions = []string{".avi", ".mkv", ".mpg", ".wmv"} // The default of -extensions
+}
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		want ReleaseInfo
+	}{
+		{"Show.Name.S02E05.720p.HDTV.x264-GROUP.mkv", ReleaseInfo{
+			Title: "Show Name", Season: 2, HasSeason: true, Episodes: []int{5},
+			Resolution: "720p", Source: "HDTV", Codec: "x264", Group: "GROUP"}},
+		{"show name s02e05.avi", ReleaseInfo{Title: "show name", Season: 2, HasSeason: true, Episodes: []int{5}}},
+		{"Show.S01E01E02.mkv", ReleaseInfo{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{1, 2}}},
+		{"Show.S01E01-03.mkv", ReleaseInfo{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{1, 2, 3}}},
+		{"Show.S01E01-E03.mkv", ReleaseInfo{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{1, 2, 3}}},
+		{"Show 1x05.mkv", ReleaseInfo{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{5}}},
+		{"Show 1x05-06.mkv", ReleaseInfo{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{5, 6}}},
+		{"Season 2", ReleaseInfo{Season: 2, HasSeason: true}},
+		{"Show.Name.S03.1080p.BluRay.x265-GROUP", ReleaseInfo{
+			Title: "Show Name", Season: 3, HasSeason: true,
+			Resolution: "1080p", Source: "BluRay", Codec: "x265", Group: "GROUP"}},
+
+		// Years
+		{"Movie.Name.2010.1080p.BluRay.x264-GROUP.mkv", ReleaseInfo{
+			Title: "Movie Name", Year: 2010, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "GROUP"}},
+		{"2001.A.Space.Odyssey.1968.1080p.mkv", ReleaseInfo{Title: "2001 A Space Odyssey", Year: 1968, Resolution: "1080p"}},
+		{"Blade.Runner.2049.2017.2160p.mkv", ReleaseInfo{Title: "Blade Runner 2049", Year: 2017, Resolution: "2160p"}},
+		{"Show.2019.S01E02.mkv", ReleaseInfo{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{2}, Year: 2019}},
+
+		// Groups
+		{"Spider-Man.mkv", ReleaseInfo{Title: "Spider-Man"}},
+		{"Spider-Man.2002.mkv", ReleaseInfo{Title: "Spider-Man", Year: 2002}},
+		{"Movie.2015.1080p.WEB-DL.mkv", ReleaseInfo{Title: "Movie", Year: 2015, Resolution: "1080p", Source: "WEB-DL"}},
+		{"Movie.2015.1080p.Blu-ray.mkv", ReleaseInfo{Title: "Movie", Year: 2015, Resolution: "1080p", Source: "BluRay"}},
+		{"Show.S01E05-GRP.mkv", ReleaseInfo{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{5}, Group: "GRP"}},
+		{"Show.S01E01-03-GRP.mkv", ReleaseInfo{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{1, 2, 3}, Group: "GRP"}},
+		{"[Group] Show - 1x02 [720p].mkv", ReleaseInfo{
+			Title: "Show", Season: 1, HasSeason: true, Episodes: []int{2}, Resolution: "720p", Group: "Group"}},
+
+		// Resolutions aren't episodes
+		{"Movie.1920x1080.mkv", ReleaseInfo{Title: "Movie 1920x1080"}},
+		{"Clip 1280x720.mp4", ReleaseInfo{Title: "Clip 1280x720"}},
+	}
+	for _, test := range tests {
+		if got := ParseRelease(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseRelease(%q) = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReleaseEpisodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		is_episode  bool
+		first, last int
+	}{
+		{"Show.S02E05.mkv", true, 5, 5},
+		{"Show.S01E01E02.mkv", true, 1, 2},
+		{"Show 1x05-06.mkv", true, 5, 6},
+		{"Season 2", false, 0, 0},
+		{"Movie.2010.mkv", false, 0, 0},
+	}
+	for _, test := range tests {
+		release := ParseRelease(test.name)
+		if release.IsEpisode() != test.is_episode || release.Episode() != test.first || release.LastEpisode() != test.last {
+			t.Errorf("%q: IsEpisode, Episode, LastEpisode = %v, %d, %d, want %v, %d, %d", test.name,
+				release.IsEpisode(), release.Episode(), release.LastEpisode(), test.is_episode, test.first, test.last)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package backend
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Orders that listings can be sorted in
+const (
+	SORT_NAME      = "name"    // Names in natural order, see NaturalLess
+	SORT_EPISODE   = "episode" // By show, season and episode, then by name
+	SORT_MTIME     = "mtime"   // By when files were last modified
+	SORT_CTIME     = "ctime"   // By when files last changed, including renames
+	SORT_SIZE      = "size"
+	SORT_EXTENSION = "extension"
+)
+
+// The orders in the order that SortMode.Next goes through them
+var sort_orders = []string{SORT_NAME, SORT_EPISODE, SORT_MTIME, SORT_CTIME, SORT_SIZE, SORT_EXTENSION}
+
+var (
+	// SortBy is the order that listings start out sorted in.
+	SortBy string = SORT_NAME
+	// DirsFirst puts directories before files in listings that start out.
+	DirsFirst bool = false
+)
+
+// SortMode is the order of a listing. Entries that are equal in the order
+// are sorted by name.
+type SortMode struct {
+	By         string // One of the SORT_ constants
+	Descending bool
+	DirsFirst  bool
+}
+
+// DefaultSortMode returns the mode that listings start out in.
+func DefaultSortMode() SortMode {
+	return SortMode{By: SortBy, Descending: sorts_descending(SortBy), DirsFirst: DirsFirst}
+}
+
+// IsSortOrder reports whether by is one of the SORT_ constants.
+func IsSortOrder(by string) bool {
+	for _, order := range sort_orders {
+		if by == order {
+			return true
+		}
+	}
+	return false
+}
+
+// sorts_descending reports whether the order by starts out descending, so
+// that the newest and the biggest files come first.
+func sorts_descending(by string) bool {
+	return by == SORT_MTIME || by == SORT_CTIME || by == SORT_SIZE
+}
+
+// Next returns the mode that sorts by the order after that of m.
+func (m SortMode) Next() SortMode {
+	next := sort_orders[0]
+	for i, order := range sort_orders {
+		if order == m.By && i+1 < len(sort_orders) {
+			next = sort_orders[i+1]
+		}
+	}
+	return SortMode{By: next, Descending: sorts_descending(next), DirsFirst: m.DirsFirst}
+}
+
+func (m SortMode) String() (s string) {
+	s = m.By
+	if m.By == "" {
+		s = "unsorted"
+	} else if m.Descending {
+		s += " desc"
+	}
+	if m.DirsFirst {
+		s += ", dirs first"
+	}
+	return
+}
+
+// NaturalLess reports whether a goes before b in natural order, where case
+// is ignored and numbers are compared by value, so that Episode 2 comes
+// before Episode 10.
+func NaturalLess(a, b string) bool {
+	return natural_compare(a, b) < 0
+}
+
+func natural_compare(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if is_digit(a[i]) && is_digit(b[j]) {
+			start_a, start_b := i, j
+			for i < len(a) && is_digit(a[i]) {
+				i++
+			}
+			for j < len(b) && is_digit(b[j]) {
+				j++
+			}
+			number_a := strings.TrimLeft(a[start_a:i], "0")
+			number_b := strings.TrimLeft(b[start_b:j], "0")
+			if len(number_a) != len(number_b) {
+				return compare_ints(len(number_a), len(number_b))
+			}
+			if c := strings.Compare(number_a, number_b); c != 0 {
+				return c
+			}
+			continue
+		}
+
+		rune_a, size_a := utf8.DecodeRuneInString(a[i:])
+		rune_b, size_b := utf8.DecodeRuneInString(b[j:])
+		if lower_a, lower_b := unicode.ToLower(rune_a), unicode.ToLower(rune_b); lower_a != lower_b {
+			return compare_ints(int(lower_a), int(lower_b))
+		}
+		i += size_a
+		j += size_b
+	}
+	if c := compare_ints(len(a)-i, len(b)-j); c != 0 {
+		return c
+	}
+	// Names that differ only in case or leading zeros still need an order
+	return strings.Compare(a, b)
+}
+
+// compare_paths compares paths folder by folder, so that everything in a
+// folder stays together.
+func compare_paths(a, b string) int {
+	parts_a := strings.Split(a, string(os.PathSeparator))
+	parts_b := strings.Split(b, string(os.PathSeparator))
+	for i := 0; i < len(parts_a) && i < len(parts_b); i++ {
+		if c := natural_compare(parts_a[i], parts_b[i]); c != 0 {
+			return c
+		}
+	}
+	return compare_ints(len(parts_a), len(parts_b))
+}
+
+func compare_entries(a, b *FileEntry, mode SortMode) int {
+	if mode.DirsFirst && a.IsDir != b.IsDir {
+		if a.IsDir {
+			return -1
+		}
+		return 1
+	}
+
+	if mode.By == "" {
+		return 0 // Unsorted, so keep the order
+	}
+
+	var c int
+	switch mode.By {
+	case SORT_EPISODE:
+		c = compare_episodes(a, b)
+	case SORT_MTIME:
+		c = compare_times(a.ModTime(), b.ModTime())
+	case SORT_CTIME:
+		c = compare_times(a.ChangeTime(), b.ChangeTime())
+	case SORT_SIZE:
+		c = compare_ints64(a.Size(), b.Size())
+	case SORT_EXTENSION:
+		c = natural_compare(a.Extension(), b.Extension())
+	case SORT_NAME:
+		c = compare_paths(a.AbsPath, b.AbsPath)
+	}
+	if mode.Descending {
+		c = -c
+	}
+	if c != 0 {
+		return c
+	}
+	return compare_paths(a.AbsPath, b.AbsPath)
+}
+
+// compare_episodes orders entries by show, then season and episode. Entries
+// without a season come before the seasons of their show.
+func compare_episodes(a, b *FileEntry) int {
+	title_a, season_a := a.show_release()
+	title_b, season_b := b.show_release()
+	if c := natural_compare(strings.ToLower(title_a), strings.ToLower(title_b)); c != 0 {
+		return c
+	}
+	if c := compare_ints(season_a, season_b); c != 0 {
+		return c
+	}
+	return compare_ints(a.Release().Episode(), b.Release().Episode())
+}
+
+// show_release returns the title of the show that the entry belongs to, and
+// its season, or -1 if it has none. Episodes named like Season 1/05.mkv get
+// them from the folders they are in.
+func (fe *FileEntry) show_release() (title string, season int) {
+	release := fe.Release()
+	title, season = release.Title, -1
+	if release.HasSeason {
+		season = release.Season
+		if title != "" {
+			return
+		}
+	}
+
+	dir := filepath.Dir(fe.AbsPath)
+	dir_release := ParseRelease(filepath.Base(dir))
+	if !dir_release.HasSeason {
+		return
+	}
+	if season < 0 {
+		season = dir_release.Season
+	}
+	title = dir_release.Title
+	if title == "" {
+		title = ParseRelease(filepath.Base(filepath.Dir(dir))).Title
+	}
+	return
+}
+
+// SortEntries sorts a list of *FileEntry in the order of mode. The elements
+// are moved rather than replaced, so that ElementInParent stays valid.
+func SortEntries(entries *list.List, mode SortMode) {
+	elements := make([]*list.Element, 0, entries.Len())
+	for e := entries.Front(); e != nil; e = e.Next() {
+		elements = append(elements, e)
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		return compare_entries(elements[i].Value.(*FileEntry), elements[j].Value.(*FileEntry), mode) < 0
+	})
+	for _, e := range elements {
+		entries.MoveToBack(e)
+	}
+}
+
+// SortContents sorts the contents of a directory in the order of mode,
+// unless they already are.
+func (fe *FileEntry) SortContents(mode SortMode) {
+	if fe.sorted != mode {
+		SortEntries(&fe.Contents, mode)
+		fe.sorted = mode
+	}
+}
+
+func is_digit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func compare_times(a, b time.Time) int {
+	if a.Before(b) {
+		return -1
+	} else if a.After(b) {
+		return 1
+	}
+	return 0
+}
+
+func compare_ints64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compare_ints(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,236 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/chrigrah/nextplz/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// Positions this close to the start or end of a file are not worth
+	// resuming from.
+	resume_min_position = 10
+	resume_end_margin   = 30
+)
+
+// GlobalState remembers things about the files that have been played.
+var GlobalState *StateStore = NewStateStore("")
+
+// FileState is what is remembered about a file. Size and ModTime identify
+// the version of the file the state belongs to. Name and Size are used to
+// find the state of files that have been moved.
+type FileState struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"`
+	Position int    `json:"position,omitempty"`
+	Length   int    `json:"length,omitempty"`
+	Watched  bool   `json:"watched,omitempty"`
+}
+
+// StateStore is a persistent map from file path to FileState, saved as JSON.
+type StateStore struct {
+	path       string
+	lock       sync.Mutex
+	files      map[string]*FileState
+	by_name    map[string]string // name and size -> path
+	names      map[string]bool
+	generation uint
+}
+
+func DefaultStatePath() string {
+	return filepath.Join(util.ConfigDir(), "state.json")
+}
+
+// NewStateStore creates an empty store that saves to path, or nowhere if path
+// is empty.
+func NewStateStore(path string) *StateStore {
+	return &StateStore{path: path, files: make(map[string]*FileState), by_name: make(map[string]string), names: make(map[string]bool)}
+}
+
+// LoadStateStore loads the store saved at path. A missing file results in an
+// empty store. If the file can't be read, an empty store is returned along
+// with the error.
+func LoadStateStore(path string) (*StateStore, error) {
+	ss := NewStateStore(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ss, nil
+	} else if err != nil {
+		return ss, err
+	}
+	if err = json.Unmarshal(data, &ss.files); err != nil {
+		ss.files = make(map[string]*FileState)
+		return ss, err
+	}
+	for file, state := range ss.files {
+		ss.by_name[moved_key(state.Name, state.Size)] = file
+		ss.names[state.Name] = true
+	}
+	return ss, nil
+}
+
+// Generation changes every time the store is modified, so that cached
+// presentations of the state can be thrown away.
+func (ss *StateStore) Generation() uint {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.generation
+}
+
+// Get returns the state of file, if there is any for the current version of
+// the file. A file without state of its own inherits the state of a file
+// with the same name and size that is gone, as it has most likely been moved.
+func (ss *StateStore) Get(file string) (state FileState, ok bool) {
+	return ss.get(file, nil)
+}
+
+// GetEntry is Get for an entry of a listing. The size and modification time
+// of its file are known from when its directory was read, so the file isn't
+// looked at again.
+func (ss *StateStore) GetEntry(entry *FileEntry) (state FileState, ok bool) {
+	return ss.get(entry.AbsPath, entry.Info())
+}
+
+func (ss *StateStore) get(file string, info os.FileInfo) (state FileState, ok bool) {
+	ss.lock.Lock()
+	known := ss.names[filepath.Base(file)]
+	ss.lock.Unlock()
+	if !known {
+		return FileState{}, false // Spare the stat for the vast majority of files
+	}
+
+	size, mtime, err := file_identity(file, info)
+	if err != nil {
+		return FileState{}, false
+	}
+
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if stored, ok := ss.files[file]; ok && stored.Size == size && stored.ModTime == mtime {
+		return *stored, true
+	}
+	if old_path, ok := ss.find_moved(file, size); ok {
+		return *ss.files[old_path], true
+	}
+	return FileState{}, false
+}
+
+func (ss *StateStore) find_moved(file string, size int64) (old_path string, ok bool) {
+	old_path, ok = ss.by_name[moved_key(filepath.Base(file), size)]
+	if !ok || old_path == file {
+		return "", false
+	}
+	if _, err := os.Stat(old_path); !os.IsNotExist(err) {
+		return "", false // Not moved, just a copy
+	}
+	return old_path, true
+}
+
+func moved_key(name string, size int64) string {
+	return fmt.Sprintf("%d/%s", size, name)
+}
+
+// ResumePosition returns the position playback of file was stopped at, or 0.
+func (ss *StateStore) ResumePosition(file string) int {
+	state, _ := ss.Get(file)
+	return state.Position
+}
+
+func (ss *StateStore) IsWatched(file string) bool {
+	state, _ := ss.Get(file)
+	return state.Watched
+}
+
+// SetWatched marks file as watched or not. A watched file starts over the
+// next time it is played.
+func (ss *StateStore) SetWatched(file string, watched bool) error {
+	return ss.update(file, func(state *FileState) {
+		state.Watched = watched
+		if watched {
+			state.Position = 0
+		}
+	})
+}
+
+func (ss *StateStore) ToggleWatched(file string) error {
+	return ss.SetWatched(file, !ss.IsWatched(file))
+}
+
+// SetPosition records where playback of file stopped. Positions near the
+// start or the end are stored as 0, so that the file starts over next time.
+func (ss *StateStore) SetPosition(file string, position, length int) error {
+	if position < resume_min_position || (length > 0 && position > length-resume_end_margin) {
+		position = 0
+	}
+	return ss.update(file, func(state *FileState) {
+		state.Position = position
+		if length > 0 {
+			state.Length = length
+		}
+	})
+}
+
+func (ss *StateStore) update(file string, modify func(*FileState)) error {
+	size, mtime, err := file_identity(file, nil)
+	if err != nil {
+		return err
+	}
+
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	state, ok := ss.files[file]
+	if !ok || state.Size != size || state.ModTime != mtime {
+		state = &FileState{Name: filepath.Base(file), Size: size, ModTime: mtime}
+		if old_path, moved := ss.find_moved(file, size); moved {
+			*state = *ss.files[old_path]
+			state.ModTime = mtime
+			delete(ss.files, old_path)
+		}
+		ss.files[file] = state
+		ss.by_name[moved_key(state.Name, state.Size)] = file
+		ss.names[state.Name] = true
+	}
+	modify(state)
+	ss.generation++
+
+	return ss.save()
+}
+
+// save writes the store to a temporary file which then replaces the old one,
+// so that a crash never leaves a half written store behind.
+func (ss *StateStore) save() error {
+	if ss.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ss.files, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(ss.path), 0755); err != nil {
+		return err
+	}
+	tmp_path := ss.path + ".tmp"
+	if err = ioutil.WriteFile(tmp_path, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp_path, ss.path)
+}
+
+// file_identity returns the size and modification time of file, from info if
+// it is known. Symlinks are followed, so that they share the state of the
+// file they point to.
+func file_identity(file string, info os.FileInfo) (size int64, mtime int64, err error) {
+	if info == nil || info.Mode()&os.ModeSymlink != 0 {
+		if info, err = os.Stat(file); err != nil {
+			return 0, 0, err
+		}
+	}
+	return info.Size(), info.ModTime().Unix(), nil
+}
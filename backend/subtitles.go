@@ -0,0 +1,316 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	AutoSubtitles bool = true
+
+	SubtitleExtensions = []string{".srt", ".sub", ".idx", ".ass", ".ssa", ".vtt"}
+
+	// Preferred subtitle languages, most preferred first. Subtitles in none
+	// of them are still picked if there is nothing better.
+	SubtitleLanguages []string
+
+	subtitle_dir_names = []string{"subs", "sub", "subtitles", "subtitle"}
+
+	// Like 2_English in Subs/Show.S01E01/2_English.srt
+	numbered_subtitle_re = regexp.MustCompile(`^\d+_`)
+	// A language optionally followed by a region or script, like pt-br
+	language_tag_re = regexp.MustCompile(`^([a-z]{2,})(?:[-_][a-z]{2,4})?$`)
+
+	dir_cache_lock sync.Mutex
+	dir_cache      = make(map[string]*cached_dir)
+)
+
+// Common spellings of languages in subtitle file names, so that en, eng and
+// english all mean the same thing.
+var language_aliases = map[string]string{
+	"eng": "en", "english": "en",
+	"swe": "sv", "swedish": "sv", "svenska": "sv",
+	"nor": "no", "nob": "no", "nb": "no", "norwegian": "no", "norsk": "no",
+	"dan": "da", "danish": "da", "dansk": "da",
+	"fin": "fi", "finnish": "fi", "suomi": "fi",
+	"ger": "de", "deu": "de", "german": "de", "deutsch": "de",
+	"fre": "fr", "fra": "fr", "french": "fr",
+	"spa": "es", "spanish": "es",
+	"ita": "it", "italian": "it",
+	"dut": "nl", "nld": "nl", "dutch": "nl",
+	"por": "pt", "portuguese": "pt",
+	"rus": "ru", "russian": "ru",
+	"pol": "pl", "polish": "pl",
+	"jpn": "ja", "japanese": "ja",
+	"chi": "zh", "zho": "zh", "chinese": "zh",
+	"kor": "ko", "korean": "ko",
+	"ara": "ar", "arabic": "ar",
+	"tur": "tr", "turkish": "tr",
+	"gre": "el", "ell": "el", "greek": "el",
+	"heb": "he", "hebrew": "he",
+	"hun": "hu", "hungarian": "hu",
+	"cze": "cs", "ces": "cs", "czech": "cs",
+	"rum": "ro", "ron": "ro", "romanian": "ro",
+	"ice": "is", "isl": "is", "icelandic": "is",
+	"est": "et", "estonian": "et",
+	"lav": "lv", "latvian": "lv",
+	"lit": "lt", "lithuanian": "lt",
+	"ukr": "uk", "ukrainian": "uk",
+	"bul": "bg", "bulgarian": "bg",
+	"hrv": "hr", "croatian": "hr",
+	"srp": "sr", "serbian": "sr",
+	"slo": "sk", "slk": "sk", "slovak": "sk",
+	"slv": "sl", "slovenian": "sl",
+	"tha": "th", "thai": "th",
+	"vie": "vi", "vietnamese": "vi",
+	"ind": "id", "indonesian": "id",
+	"hin": "hi", "hindi": "hi",
+	"per": "fa", "fas": "fa", "persian": "fa",
+	"may": "ms", "msa": "ms", "malay": "ms",
+	"cat": "ca", "catalan": "ca",
+}
+
+// The two letter ISO 639-1 codes. Together with the spellings in
+// language_aliases, these are the languages that subtitles are recognized to
+// be tagged with, so that tags like forced or sdh aren't taken for languages.
+const iso_639_1_codes = "aa ab ae af ak am an ar as av ay az ba be bg bh bi bm bn bo br bs ca ce ch co cr cs cu cv cy " +
+	"da de dv dz ee el en eo es et eu fa ff fi fj fo fr fy ga gd gl gn gu gv ha he hi ho hr ht hu hy hz " +
+	"ia id ie ig ii ik io is it iu ja jv ka kg ki kj kk kl km kn ko kr ks ku kv kw ky la lb lg li ln lo lt lu lv " +
+	"mg mh mi mk ml mn mr ms mt my na nb nd ne ng nl nn no nr nv ny oc oj om or os pa pi pl ps pt qu " +
+	"rm rn ro ru rw sa sc sd se sg si sk sl sm sn so sq sr ss st su sv sw ta te tg th ti tk tl tn to tr ts tt tw ty " +
+	"ug uk ur uz ve vi vo wa wo xh yi yo za zh zu"
+
+// Subtitle is a subtitle file belonging to a video. Language is as written in
+// the file name, and empty if it doesn't say.
+type Subtitle struct {
+	Path     string
+	Language string
+}
+
+func IsSubtitle(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, sub_ext := range SubtitleExtensions {
+		if ext == sub_ext {
+			return true
+		}
+	}
+	return false
+}
+
+// FindSubtitles returns the subtitles of video. Subtitles are named like the
+// video, optionally with a language tag (video.en.srt), and are looked for
+// next to the video and in a Subs folder beside it. In a Subs folder, a
+// folder named like the video holds its subtitles, and in a folder with a
+// single video all the subtitles belong to it.
+func FindSubtitles(video string) (subtitles []Subtitle) {
+	dir := filepath.Dir(video)
+	base := strings.TrimSuffix(filepath.Base(video), filepath.Ext(video))
+
+	entries := read_dir_cached(dir)
+	subtitles = append(subtitles, match_subtitles(dir, base, entries)...)
+
+	single_video := count_videos(entries) == 1
+	for _, entry := range entries {
+		if !entry.IsDir() || !is_subtitle_dir_name(entry.Name()) {
+			continue
+		}
+		subs_dir := filepath.Join(dir, entry.Name())
+		subs_entries := read_dir_cached(subs_dir)
+		subtitles = append(subtitles, match_subtitles(subs_dir, base, subs_entries)...)
+		for _, subs_entry := range subs_entries {
+			if subs_entry.IsDir() && strings.EqualFold(subs_entry.Name(), base) {
+				video_subs_dir := filepath.Join(subs_dir, subs_entry.Name())
+				subtitles = append(subtitles, any_subtitles(video_subs_dir, read_dir_cached(video_subs_dir))...)
+			}
+		}
+		if single_video {
+			subtitles = append(subtitles, any_subtitles(subs_dir, subs_entries)...)
+		}
+	}
+	return unique_subtitles(subtitles)
+}
+
+// HasSubtitles is FindSubtitles for when the subtitles themselves don't
+// matter.
+func HasSubtitles(video string) bool {
+	return len(FindSubtitles(video)) > 0
+}
+
+// PreferredSubtitle picks the subtitle of video to play it with: the first
+// one in a preferred language, or one without a language, or whatever there
+// is. The empty string means no subtitle, which is also what is returned if
+// AutoSubtitles is off.
+func PreferredSubtitle(video string) string {
+	if !AutoSubtitles {
+		return ""
+	}
+	subtitles := FindSubtitles(video)
+	if len(subtitles) == 0 {
+		return ""
+	}
+	for _, preferred := range SubtitleLanguages {
+		preferred = NormalizeLanguage(preferred)
+		for _, subtitle := range subtitles {
+			if NormalizeLanguage(subtitle.Language) == preferred {
+				return subtitle.Path
+			}
+		}
+	}
+	for _, subtitle := range subtitles {
+		if subtitle.Language == "" {
+			return subtitle.Path
+		}
+	}
+	return subtitles[0].Path
+}
+
+// NormalizeLanguage turns the common ways of writing a language into its two
+// letter code. Unknown languages are just lower cased.
+func NormalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if code, ok := language_aliases[language]; ok {
+		return code
+	}
+	return language
+}
+
+// is_language_tag reports whether tag, like en, eng, english or pt-br, is a
+// known language.
+func is_language_tag(tag string) bool {
+	matches := language_tag_re.FindStringSubmatch(tag)
+	if matches == nil {
+		return false
+	}
+	if _, ok := language_aliases[matches[1]]; ok {
+		return true
+	}
+	return len(matches[1]) == 2 && strings.Contains(" "+iso_639_1_codes+" ", " "+matches[1]+" ")
+}
+
+// match_subtitles returns the subtitles in dir named like the video base,
+// possibly followed by a language tag.
+func match_subtitles(dir, base string, entries []os.FileInfo) (subtitles []Subtitle) {
+	lower_base := strings.ToLower(base)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !IsSubtitle(name) || is_vobsub_data(name, entries) {
+			continue
+		}
+		sub_base := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+		if sub_base == lower_base {
+			subtitles = append(subtitles, Subtitle{filepath.Join(dir, name), ""})
+		} else if tag := strings.TrimPrefix(sub_base, lower_base+"."); tag != sub_base {
+			// Like en in .en.forced or .sdh.en
+			for _, language := range strings.Split(tag, ".") {
+				if is_language_tag(language) {
+					subtitles = append(subtitles, Subtitle{filepath.Join(dir, name), language})
+					break
+				}
+			}
+		}
+	}
+	return
+}
+
+// any_subtitles returns all subtitles in dir, taking the language from their
+// names.
+func any_subtitles(dir string, entries []os.FileInfo) (subtitles []Subtitle) {
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !IsSubtitle(name) || is_vobsub_data(name, entries) {
+			continue
+		}
+		language := strings.TrimSuffix(name, filepath.Ext(name))
+		language = numbered_subtitle_re.ReplaceAllString(language, "")
+		if i := strings.LastIndex(language, "."); i >= 0 {
+			language = language[i+1:]
+		}
+		subtitles = append(subtitles, Subtitle{filepath.Join(dir, name), strings.ToLower(language)})
+	}
+	return
+}
+
+// is_vobsub_data reports whether name is the .sub half of a VobSub pair.
+// Players want the .idx.
+func is_vobsub_data(name string, entries []os.FileInfo) bool {
+	if strings.ToLower(filepath.Ext(name)) != ".sub" {
+		return false
+	}
+	idx := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)) + ".idx")
+	for _, entry := range entries {
+		if strings.ToLower(entry.Name()) == idx {
+			return true
+		}
+	}
+	return false
+}
+
+func is_subtitle_dir_name(name string) bool {
+	for _, subs_name := range subtitle_dir_names {
+		if strings.EqualFold(name, subs_name) {
+			return true
+		}
+	}
+	return false
+}
+
+func count_videos(entries []os.FileInfo) (videos int) {
+	for _, entry := range entries {
+		if !entry.IsDir() && IsVideo(entry.Name()) {
+			videos++
+		}
+	}
+	return
+}
+
+func unique_subtitles(subtitles []Subtitle) []Subtitle {
+	seen := make(map[string]bool)
+	unique := subtitles[:0]
+	for _, subtitle := range subtitles {
+		if !seen[subtitle.Path] {
+			seen[subtitle.Path] = true
+			unique = append(unique, subtitle)
+		}
+	}
+	return unique
+}
+
+type cached_dir struct {
+	mtime   time.Time
+	entries []os.FileInfo
+}
+
+// read_dir_cached lists dir, reusing the last listing as long as the
+// directory hasn't been modified. Listings ask for the subtitles of every
+// video they show, so this saves reading the same directory over and over.
+func read_dir_cached(dir string) []os.FileInfo {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+
+	dir_cache_lock.Lock()
+	cached, ok := dir_cache[dir]
+	dir_cache_lock.Unlock()
+	if ok && cached.mtime.Equal(info.ModTime()) {
+		return cached.entries
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	entries, _ := f.Readdir(-1)
+	f.Close()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	dir_cache_lock.Lock()
+	dir_cache[dir] = &cached_dir{info.ModTime(), entries}
+	dir_cache_lock.Unlock()
+	return entries
+}
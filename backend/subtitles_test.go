@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLanguageTags(t *testing.T) {
+	tests := []struct {
+		tag         string
+		is_language bool
+	}{
+		{"en", true},
+		{"eng", true},
+		{"english", true},
+		{"pt-br", true},
+		{"zh_hans", true},
+		{"svenska", true},
+		{"forced", false},
+		{"sdh", false},
+		{"hearing", false},
+		{"cc", false},
+		{"720p", false},
+		{"xx", false},
+	}
+	for _, test := range tests {
+		if is_language_tag(test.tag) != test.is_language {
+			t.Errorf("is_language_tag(%q) = %v, want %v", test.tag, !test.is_language, test.is_language)
+		}
+	}
+}
+
+func TestFindSubtitlesLanguages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nextplz-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{"Show.S01E01.mkv", "Show.S01E01.en.srt", "Show.S01E01.en.forced.srt",
+		"Show.S01E01.sdh.swe.srt", "Show.S01E01.forced.srt", "Show.S01E01.sdh.srt"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var found []string
+	for _, subtitle := range FindSubtitles(filepath.Join(dir, "Show.S01E01.mkv")) {
+		found = append(found, filepath.Base(subtitle.Path)+" "+subtitle.Language)
+	}
+	sort.Strings(found)
+	want := []string{"Show.S01E01.en.forced.srt en", "Show.S01E01.en.srt en", "Show.S01E01.sdh.swe.srt swe"}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("FindSubtitles() = %q, want %q", found, want)
+	}
+}
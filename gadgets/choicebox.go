@@ -0,0 +1,115 @@
+package gadgets
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chrigrah/nextplz/util"
+	"github.com/nsf/termbox-go"
+)
+
+var (
+	ChoiceBoxIsOpen bool = false
+)
+
+// ChoiceBox is a popup that asks the user to pick one of a few choices. The
+// FinalizeCallback is called with the picked choice.
+type ChoiceBox struct {
+	question      string
+	choices       []string
+	selected      int
+	X, Y          int
+	Width, Height int
+
+	FinalizeCallback func(string) error
+}
+
+func CreateChoiceBox(question string, choices []string, maxwidth, maxheight int) (*ChoiceBox, error) {
+	if len(choices) == 0 {
+		return nil, errors.New("Nothing to choose from")
+	}
+	var cb ChoiceBox
+	cb.question = question
+	cb.choices = choices
+
+	cb.Height = len(choices) + 4 // borders, question and a blank line
+	if cb.Height > maxheight {
+		return nil, errors.New(fmt.Sprintf(
+			"Not enough room provided for choicebox. maxheight:%d needed_rows:%d", maxheight, cb.Height))
+	}
+	cb.Width = len(question)
+	for _, choice := range choices {
+		cb.Width = util.Max(cb.Width, len(choice)+2)
+	}
+	cb.Width = util.Min(util.Max(cb.Width+horizontal_overhead, comfortable_width), maxwidth)
+	cb.X = maxwidth/2 - cb.Width/2
+	cb.Y = maxheight/2 - cb.Height/2
+
+	ChoiceBoxIsOpen = true
+	return &cb, nil
+}
+
+// Select highlights choice, if it is one of the choices.
+func (cb *ChoiceBox) Select(choice string) {
+	for i, c := range cb.choices {
+		if c == choice {
+			cb.selected = i
+		}
+	}
+}
+
+func (cb *ChoiceBox) Input(event termbox.Event) error {
+	switch event.Key {
+	case termbox.KeyCtrlU:
+		fallthrough
+	case termbox.KeyArrowDown:
+		if cb.selected < len(cb.choices)-1 {
+			cb.selected++
+		}
+	case termbox.KeyCtrlI:
+		fallthrough
+	case termbox.KeyArrowUp:
+		if cb.selected > 0 {
+			cb.selected--
+		}
+	}
+	return nil
+}
+
+func (cb *ChoiceBox) SetFinalizeCallback(callback func(string) error) {
+	cb.FinalizeCallback = callback
+}
+
+func (cb *ChoiceBox) Finalize() IRStatus {
+	err := cb.FinalizeCallback(cb.choices[cb.selected])
+	ChoiceBoxIsOpen = false
+	return IRStatus{true, err}
+}
+
+func (cb *ChoiceBox) HandleEscape() bool {
+	return false
+}
+
+func (cb *ChoiceBox) Deactivate() error {
+	ChoiceBoxIsOpen = false
+	return nil
+}
+
+func (cb *ChoiceBox) Draw(is_focused bool) error {
+	draw_box(cb.X, cb.Y, cb.Width, cb.Height)
+	fill_box(cb.X, cb.Y, cb.Width, cb.Height)
+
+	line_width := cb.Width - horizontal_overhead
+	util.WriteString(cb.X+2, cb.Y+1, line_width, termbox.ColorWhite, termbox.ColorBlue, cb.question)
+	for i, choice := range cb.choices {
+		fg, bg := termbox.ColorWhite, termbox.ColorBlue
+		if i == cb.selected {
+			bg = termbox.ColorMagenta
+		}
+		util.WriteString(cb.X+2, cb.Y+3+i, line_width, fg, bg, " "+choice)
+	}
+	return nil
+}
+
+func (cb *ChoiceBox) Resize(width, height int) error {
+	return nil
+}
@@ -0,0 +1,118 @@
+package gadgets
+
+import (
+	"fmt"
+	"github.com/chrigrah/nextplz/util"
+	"github.com/nsf/termbox-go"
+	"time"
+)
+
+var (
+	CountdownIsOpen bool = false
+)
+
+// Expirer is implemented by gadgets that finalize themselves after a while.
+// main finalizes the focused gadget as if Enter was pressed once it has
+// expired.
+type Expirer interface {
+	Expired() bool
+}
+
+// Countdown is a popup that counts down to something happening, like the
+// next episode starting. Enter makes it happen right away and Escape cancels
+// it. The FinalizeCallback is called with the value the countdown was
+// created with.
+type Countdown struct {
+	message       string
+	value         string
+	deadline      time.Time
+	X, Y          int
+	Width, Height int
+	stop          chan struct{}
+	stopped       bool
+
+	FinalizeCallback func(string) error
+}
+
+// CreateCountdown counts down seconds, showing message above the time left.
+// update_chan is poked every second, so that the countdown is redrawn and
+// checked for expiry.
+func CreateCountdown(message, value string, seconds, maxwidth, maxheight int, update_chan chan int) *Countdown {
+	cd := &Countdown{
+		message:  message,
+		value:    value,
+		deadline: time.Now().Add(time.Duration(seconds) * time.Second),
+		Height:   5, // borders, message and time left
+		stop:     make(chan struct{}),
+	}
+	cd.Width = util.Min(util.Max(len(message)+horizontal_overhead, comfortable_width), maxwidth)
+	cd.X = maxwidth/2 - cd.Width/2
+	cd.Y = maxheight/2 - cd.Height/2
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case update_chan <- 1:
+				default: // An update is already pending
+				}
+			case <-cd.stop:
+				return
+			}
+		}
+	}()
+
+	CountdownIsOpen = true
+	return cd
+}
+
+func (cd *Countdown) Expired() bool {
+	return !time.Now().Before(cd.deadline)
+}
+
+func (cd *Countdown) Input(event termbox.Event) error {
+	return nil
+}
+
+func (cd *Countdown) SetFinalizeCallback(callback func(string) error) {
+	cd.FinalizeCallback = callback
+}
+
+func (cd *Countdown) Finalize() IRStatus {
+	return IRStatus{true, cd.FinalizeCallback(cd.value)}
+}
+
+func (cd *Countdown) HandleEscape() bool {
+	return false
+}
+
+func (cd *Countdown) Deactivate() error {
+	if !cd.stopped {
+		close(cd.stop)
+		cd.stopped = true
+	}
+	CountdownIsOpen = false
+	return nil
+}
+
+func (cd *Countdown) Draw(is_focused bool) error {
+	draw_box(cd.X, cd.Y, cd.Width, cd.Height)
+	fill_box(cd.X, cd.Y, cd.Width, cd.Height)
+
+	left := int(time.Until(cd.deadline).Seconds() + 0.5)
+	if left < 0 {
+		left = 0
+	}
+	line_width := cd.Width - horizontal_overhead
+	util.WriteString(cd.X+2, cd.Y+1, line_width, termbox.ColorWhite, termbox.ColorBlue, cd.message)
+	util.WriteString(cd.X+2, cd.Y+3, line_width, termbox.ColorYellow, termbox.ColorBlue,
+		fmt.Sprintf("in %d s (Enter: now, Esc: cancel)", left))
+	return nil
+}
+
+func (cd *Countdown) Resize(width, height int) error {
+	return nil
+}
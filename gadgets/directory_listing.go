@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/chrigrah/nextplz/backend"
-	"github.com/chrigrah/nextplz/media_player"
 	"github.com/nsf/termbox-go"
 	"os"
 )
@@ -17,10 +16,14 @@ type DirectoryListing struct {
 	current_dir            *backend.FileEntry
 	current_coloredstrings map[*backend.FileEntry]backend.ColoredScrollingString
 
-	pl PrintableListing
-	CL CommandLine
+	pl   PrintableListing
+	CL   CommandLine
+	sort backend.SortMode
 
-	tick_id uint
+	tick_id          uint
+	state_generation uint
+	media_generation uint
+	update_chan      chan int
 
 	FinalizeCallback func(string) error
 	Debug_message    string
@@ -42,10 +45,16 @@ func NewListing(startx, starty int, width, height int, update_chan chan int) *Di
 			width:        width,
 			height:       height - 1,
 		},
+		update_chan: update_chan,
+		sort:        backend.DefaultSortMode(),
 	}
 	dl.pl.ElementToFilterValue = dl_elementtofiltervalue_func()
 	dl.pl.ElementPrintValue = dl_elementprintvalue_func(dl)
 	dl.FinalizeCallback = func(_ string) error {
+		if entry, err := dl.SelectedEntry(); err == nil && !entry.IsDir && backend.IsPlaylist(entry.Name) {
+			dl.CL.Clear()
+			return dl.OpenPlaylist(entry.AbsPath)
+		}
 		dl.CdHighlighted()
 		dl.CL.Clear()
 		return nil
@@ -91,16 +100,41 @@ func fe_to_coloredstring(entry *backend.FileEntry) (cs backend.ColoredScrollingS
 		fg = termbox.ColorRed
 	} else if entry.IsVideo {
 		fg = termbox.ColorGreen
+	} else if backend.IsPlaylist(entry.Name) {
+		fg = termbox.ColorMagenta
 	} else if entry.IsDir {
 		fg = termbox.ColorCyan
 	} else {
 		fg = termbox.ColorWhite
 	}
-	cs.AppendString(entry.Name, fg)
+	cs.AppendString(entry.Name, name_color(entry, fg))
+	append_subtitle_marker(&cs, entry)
+	append_state_markers(&cs, entry)
+	append_media_info(&cs, entry)
 	return
 }
 
+func append_subtitle_marker(cs *backend.ColoredScrollingString, entry *backend.FileEntry) {
+	if entry.IsVideo && !entry.IsDir && backend.HasSubtitles(entry.AbsPath) {
+		cs.AppendString(" [sub]", termbox.ColorCyan)
+	}
+}
+
+// append_media_info shows what ffprobe says about videos, once it has said
+// it.
+func append_media_info(cs *backend.ColoredScrollingString, entry *backend.FileEntry) {
+	if info, ok := backend.GlobalMediaInfo.Get(entry); ok {
+		if summary := info.Summary(); summary != "" {
+			cs.AppendString(" ("+summary+")", termbox.ColorWhite)
+		}
+	}
+}
+
 func (dl *DirectoryListing) Input(event termbox.Event) (err error) {
+	if handled, err := handle_player_control(event); handled {
+		return err
+	}
+
 	switch event.Key {
 	case termbox.KeyF5:
 		dl.ChangeDirectory(dl.current_dir.AbsPath)
@@ -119,6 +153,17 @@ func (dl *DirectoryListing) Input(event termbox.Event) (err error) {
 		dl.pl.MoveCursorUp()
 	case termbox.KeyCtrlO:
 		dl.pl.MoveCursorRight()
+	case termbox.KeyCtrlT:
+		err = toggle_watched(&dl.pl)
+	case termbox.KeyCtrlE:
+		err = enqueue_selected(&dl.pl)
+	case termbox.KeyCtrlA:
+		err = play_from_here(&dl.pl)
+	case termbox.KeyCtrlS:
+		err = export_playlist(&dl.pl, dl.current_dir.AbsPath)
+	case termbox.KeyCtrlR, termbox.KeyCtrlG, termbox.KeyCtrlD:
+		dl.sort = change_sort(dl.sort, event.Key)
+		dl.current_dir.SortContents(dl.sort)
 	case termbox.KeyCtrlN:
 		err = dl.NextDirectory()
 	case termbox.KeyCtrlP:
@@ -127,7 +172,7 @@ func (dl *DirectoryListing) Input(event termbox.Event) (err error) {
 		file, ok := dl.pl.GetSelected()
 		if ok {
 			file_str := file.(*backend.FileEntry).AbsPath
-			media_player.GlobalMediaPlayer.PlayFile(file_str)
+			err = play_file(file_str, dl.pl.width, dl.pl.height+1)
 		} else {
 			err = errors.New(fmt.Sprintf("Could not play file: Invalid selection"))
 		}
@@ -172,6 +217,7 @@ func (dl *DirectoryListing) ChangeDirectory(dir string) error {
 		return err
 	}
 
+	cwd.SortContents(dl.sort)
 	dl.current_dir = cwd
 	dl.current_coloredstrings = make(map[*backend.FileEntry]backend.ColoredScrollingString)
 	dl.pl = PrintableListing{
@@ -188,10 +234,15 @@ func (dl *DirectoryListing) ChangeDirectory(dir string) error {
 }
 
 func (dl *DirectoryListing) Draw(is_focused bool) error {
+	state_generation, media_generation := backend.GlobalState.Generation(), backend.GlobalMediaInfo.Generation()
+	if state_generation != dl.state_generation || media_generation != dl.media_generation {
+		dl.current_coloredstrings = make(map[*backend.FileEntry]backend.ColoredScrollingString)
+		dl.state_generation, dl.media_generation = state_generation, media_generation
+	}
 	if dl.Debug_message != "" {
 		dl.pl.header = dl.Debug_message
 	} else {
-		dl.pl.header = dl.current_dir.AbsPath
+		dl.pl.header = fmt.Sprintf("%s [%s]", dl.current_dir.AbsPath, dl.sort)
 	}
 
 	dl.CL.Draw(is_focused)
@@ -212,6 +263,10 @@ func (dl *DirectoryListing) GetPrintableListing() *PrintableListing {
 	return &dl.pl
 }
 
+func (dl *DirectoryListing) SelectedEntry() (*backend.FileEntry, error) {
+	return selected_entry(&dl.pl)
+}
+
 func (dl *DirectoryListing) CdHighlighted() error {
 	if dl.pl.highlighted_element == nil {
 		return errors.New("No entry is highlighted.")
@@ -223,6 +278,17 @@ func (dl *DirectoryListing) CdHighlighted() error {
 	return dl.ChangeDir(highlighted_entry)
 }
 
+// OpenPlaylist shows the files of the playlist at path in a listing of its
+// own, where they can be played and queued like any other files.
+func (dl *DirectoryListing) OpenPlaylist(path string) error {
+	rl, err := InitPlaylistListing(dl, dl.update_chan, path)
+	if err != nil {
+		return err
+	}
+	PushGadget(rl)
+	return nil
+}
+
 func (dl *DirectoryListing) CdUp() error {
 	return dl.ChangeDir(dl.current_dir.GetParent())
 }
@@ -233,12 +299,14 @@ func (dl *DirectoryListing) ChangeDir(dir *backend.FileEntry) error {
 		dir.IsAccessible = false
 		return err
 	}
+	dir.SortContents(dl.sort)
 	dl.current_dir = dir
 	dl.pl.highlighted_element = nil
 	return nil
 }
 
 func (dl *DirectoryListing) PrevDirectory() error {
+	dl.current_dir.GetParent().SortContents(dl.sort)
 	for element := dl.current_dir.GetElementInParent().Prev(); element != nil; element = element.Prev() {
 		at_entry := element.Value.(*backend.FileEntry)
 		if !at_entry.IsDir || !at_entry.IsAccessible {
@@ -251,6 +319,7 @@ func (dl *DirectoryListing) PrevDirectory() error {
 }
 
 func (dl *DirectoryListing) NextDirectory() error {
+	dl.current_dir.GetParent().SortContents(dl.sort)
 	for element := dl.current_dir.GetElementInParent().Next(); element != nil; element = element.Next() {
 		at_entry := element.Value.(*backend.FileEntry)
 		if !at_entry.IsDir || !at_entry.IsAccessible {
@@ -262,6 +331,20 @@ func (dl *DirectoryListing) NextDirectory() error {
 	return errors.New("No next directory")
 }
 
+// change_sort returns the mode after pressing key: ctrl+r sorts by the next
+// order, ctrl+g reverses the order and ctrl+d toggles directories first.
+func change_sort(mode backend.SortMode, key termbox.Key) backend.SortMode {
+	switch key {
+	case termbox.KeyCtrlR:
+		mode = mode.Next()
+	case termbox.KeyCtrlG:
+		mode.Descending = !mode.Descending
+	case termbox.KeyCtrlD:
+		mode.DirsFirst = !mode.DirsFirst
+	}
+	return mode
+}
+
 func panic_perhaps(err error) {
 	if err != nil {
 		panic(err)
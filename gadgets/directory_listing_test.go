@@ -0,0 +1,222 @@
+package gadgets_test
+
+import (
+	"errors"
+	"github.com/chrigrah/nextplz/backend"
+	"github.com/chrigrah/nextplz/gadgets"
+	"github.com/chrigrah/nextplz/media_player"
+	"github.com/chrigrah/nextplz/media_player/playertest"
+	"github.com/nsf/termbox-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var test_files = []string{
+	"notes.txt",
+	"Show.S01E01.mkv",
+	"Show.S01E02.mkv",
+	"Show.S01E03.mkv",
+	"Season 2/Show.S02E01.mkv",
+	"Season 2/Show.S02E02.mkv",
+}
+
+func init() {
+	backend.VideoExtensions = []string{".avi", ".mkv", ".mpg", ".wmv"} // The default of -extensions
+}
+
+// setup creates test_files in a temporary directory, and a listing of it
+// that plays files with a Recorder.
+func setup(t *testing.T) (dir string, dl *gadgets.DirectoryListing, recorder *playertest.Recorder) {
+	dir, err := ioutil.TempDir("", "nextplz-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range test_files {
+		path := filepath.Join(dir, file)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder = playertest.NewRecorder()
+	media_player.SetMediaPlayer(recorder)
+	media_player.GlobalProfiles, err = media_player.LoadProfiles(filepath.Join(dir, "players.json"), &media_player.MediaPlayerInitInfo{}, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.GlobalState = backend.NewStateStore("")
+	gadgets.GlobalPlayQueue = gadgets.NewPlayQueue(0, 0, 80, 24)
+
+	dl = gadgets.NewListing(0, 0, 80, 24, drained_update_chan())
+	if err = dl.ChangeDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// drained_update_chan returns an update channel that nobody needs to read.
+func drained_update_chan() chan int {
+	update_chan := make(chan int)
+	go func() {
+		for range update_chan {
+		}
+	}()
+	return update_chan
+}
+
+func paths(dir string, files ...string) []string {
+	for i, file := range files {
+		files[i] = filepath.Join(dir, file)
+	}
+	return files
+}
+
+func check_calls(t *testing.T, recorder *playertest.Recorder, want ...string) {
+	t.Helper()
+	var calls []string
+	for _, call := range recorder.Calls() {
+		calls = append(calls, call.String())
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Player calls = %q, want %q", calls, want)
+	}
+}
+
+func TestDirectoryListingPlaysSelected(t *testing.T) {
+	dir, dl, recorder := setup(t)
+	defer os.RemoveAll(dir)
+
+	if err := playertest.Drive(dl, append(playertest.Type("e02"), playertest.Key(termbox.KeyCtrlB))...); err != nil {
+		t.Fatal(err)
+	}
+	check_calls(t, recorder, "PlayFile("+filepath.Join(dir, "Show.S01E02.mkv")+")")
+}
+
+func TestDirectoryListingEntersDirectories(t *testing.T) {
+	dir, dl, recorder := setup(t)
+	defer os.RemoveAll(dir)
+
+	events := append(playertest.Type("season"), playertest.Key(termbox.KeyEnter))
+	events = append(append(events, playertest.Type("e02")...), playertest.Key(termbox.KeyCtrlB))
+	if err := playertest.Drive(dl, events...); err != nil {
+		t.Fatal(err)
+	}
+	check_calls(t, recorder, "PlayFile("+filepath.Join(dir, "Season 2", "Show.S02E02.mkv")+")")
+}
+
+func TestDirectoryListingPlaysFromHere(t *testing.T) {
+	dir, dl, recorder := setup(t)
+	defer os.RemoveAll(dir)
+
+	events := append(playertest.Type("show"), playertest.Key(termbox.KeyArrowDown), playertest.Key(termbox.KeyCtrlA))
+	if err := playertest.Drive(dl, events...); err != nil {
+		t.Fatal(err)
+	}
+	if files := recorder.Files(); !reflect.DeepEqual(files, paths(dir, "Show.S01E02.mkv", "Show.S01E03.mkv")) {
+		t.Errorf("Files played from the second episode = %q", files)
+	}
+}
+
+func TestDirectoryListingReportsPlayerErrors(t *testing.T) {
+	dir, dl, recorder := setup(t)
+	defer os.RemoveAll(dir)
+
+	refused := errors.New("Refused")
+	recorder.SetError(refused)
+	if err := playertest.Drive(dl, append(playertest.Type("e01"), playertest.Key(termbox.KeyCtrlB))...); err != refused {
+		t.Errorf("Playing with a failing player returned %v, want %v", err, refused)
+	}
+}
+
+func TestPlayQueueFlush(t *testing.T) {
+	dir, dl, recorder := setup(t)
+	defer os.RemoveAll(dir)
+
+	queue := func(names ...string) {
+		for _, name := range names {
+			dl.CL.Clear()
+			if err := playertest.Drive(dl, append(playertest.Type(name), playertest.Key(termbox.KeyCtrlE))...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// An idle player is handed all files at once
+	queue("e01", "e03")
+	if skipped, err := gadgets.GlobalPlayQueue.Flush(); err != nil || skipped != 0 {
+		t.Fatalf("Flush() = %d, %v", skipped, err)
+	}
+	played := playertest.Call{Method: "PlayFiles", Args: []interface{}{paths(dir, "Show.S01E01.mkv", "Show.S01E03.mkv")}}
+	check_calls(t, recorder, played.String())
+	if gadgets.GlobalPlayQueue.Len() != 0 {
+		t.Errorf("%d files are left in the queue after it was flushed", gadgets.GlobalPlayQueue.Len())
+	}
+
+	// A playing one has them added to its playlist, unless it has them. A file
+	// of the same name elsewhere is not the same file.
+	recorder.Reset()
+	recorder.SetStatus(media_player.PlaybackStatus{File: filepath.Join(dir, "Show.S01E01.mkv"), Playing: true})
+	recorder.Enqueue(filepath.Join(dir, "Show.S01E01.mkv"))
+	recorder.Enqueue(filepath.Join(dir, "Other", "Show.S01E02.mkv"))
+	queue("e01", "e02")
+	if err := gadgets.GlobalPlayQueue.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if skipped, err := gadgets.GlobalPlayQueue.Flush(); err != nil || skipped != 1 {
+		t.Fatalf("Flush() = %d, %v, want 1 file skipped", skipped, err)
+	}
+	check_calls(t, recorder,
+		"Enqueue("+filepath.Join(dir, "Show.S01E01.mkv")+")",
+		"Enqueue("+filepath.Join(dir, "Other", "Show.S01E02.mkv")+")",
+		"Enqueue("+filepath.Join(dir, "Show.S01E02.mkv")+")")
+}
+
+func TestRecursiveListing(t *testing.T) {
+	dir, dl, recorder := setup(t)
+	defer os.RemoveAll(dir)
+
+	rl := gadgets.InitRecursiveFromDirectory(dl, drained_update_chan())
+	defer rl.Deactivate()
+	// The listing fills up in the background. The walk is done once the last
+	// video is in.
+	wait_for(t, rl, "s01e03")
+
+	if err := playertest.Drive(rl, append(playertest.Type("s02e02"), playertest.Key(termbox.KeyCtrlB))...); err != nil {
+		t.Fatal(err)
+	}
+	check_calls(t, recorder, "PlayFile("+filepath.Join(dir, "Season 2", "Show.S02E02.mkv")+")")
+
+	// Files are listed by path, and the highlight stays on the file played
+	recorder.Reset()
+	if err := playertest.Drive(rl, playertest.Key(termbox.KeyEsc), playertest.Key(termbox.KeyCtrlA)); err != nil {
+		t.Fatal(err)
+	}
+	want := paths(dir, "Season 2/Show.S02E02.mkv", "Show.S01E01.mkv", "Show.S01E02.mkv", "Show.S01E03.mkv")
+	if files := recorder.Files(); !reflect.DeepEqual(files, want) {
+		t.Errorf("Files played from the second video = %q, want %q", files, want)
+	}
+}
+
+// wait_for waits until filter selects a file in rl.
+func wait_for(t *testing.T, rl *gadgets.RecursiveListing, filter string) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		rl.CL.Clear()
+		if err := playertest.Drive(rl, playertest.Type(filter)...); err != nil {
+			t.Fatal(err)
+		}
+		entry, err := rl.SelectedEntry()
+		rl.CL.Clear()
+		if err == nil && entry != nil {
+			return
+		}
+	}
+	t.Fatalf("No file matching %q showed up in the recursive listing", filter)
+}
@@ -0,0 +1,46 @@
+package gadgets
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chrigrah/nextplz/backend"
+	"github.com/chrigrah/nextplz/media_player"
+)
+
+// EntrySelector is implemented by the gadgets that list files, so that
+// actions on the highlighted file can be started from outside of them.
+type EntrySelector interface {
+	SelectedEntry() (*backend.FileEntry, error)
+}
+
+func selected_entry(pl *PrintableListing) (*backend.FileEntry, error) {
+	selected, ok := pl.GetSelected()
+	if !ok {
+		return nil, errors.New("Invalid selection")
+	}
+	return selected.(*backend.FileEntry), nil
+}
+
+// CreateOpenWithBox asks which player profile the highlighted entry of
+// selector should be played with, starting at the profile last picked for
+// that kind of file.
+func CreateOpenWithBox(selector EntrySelector, maxwidth, maxheight int) (*ChoiceBox, error) {
+	entry, err := selector.SelectedEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := media_player.GlobalProfiles
+	cb, err := CreateChoiceBox(fmt.Sprintf("Open %s with:", entry.Name), profiles.Names, maxwidth, maxheight)
+	if err != nil {
+		return nil, err
+	}
+	cb.Select(profiles.LastChoice(entry.AbsPath))
+	cb.FinalizeCallback = func(name string) error {
+		if err := profiles.PlayFileWith(name, entry.AbsPath); err != nil {
+			return err
+		}
+		return profiles.RememberChoice(entry.AbsPath, name)
+	}
+	return cb, nil
+}
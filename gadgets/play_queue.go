@@ -0,0 +1,257 @@
+package gadgets
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"github.com/chrigrah/nextplz/backend"
+	"github.com/chrigrah/nextplz/media_player"
+	"github.com/nsf/termbox-go"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	PlayQueueIsOpen bool = false
+
+	// GlobalPlayQueue outlives the listings, so that files can be queued from
+	// several directories. Set by main.
+	GlobalPlayQueue *PlayQueue
+)
+
+// PlayQueue is a list of files waiting to be handed to the player. Files are
+// added from the listings, and the queue can be opened to reorder and remove
+// files before flushing it to the player.
+type PlayQueue struct {
+	pl    PrintableListing
+	files list.List
+
+	// The entries in the player's own playlist, by path for players that
+	// report paths, and by lowercased name for those that only report titles.
+	// Queued files that are already there are marked, and skipped when
+	// flushing.
+	in_player        map[string]bool
+	titles_in_player map[string]bool
+}
+
+func NewPlayQueue(startx, starty, width, height int) *PlayQueue {
+	pq := &PlayQueue{
+		pl: PrintableListing{
+			column_width: 80,
+			startx:       startx,
+			starty:       starty,
+			width:        width,
+			height:       height,
+		},
+		in_player:        make(map[string]bool),
+		titles_in_player: make(map[string]bool),
+	}
+	pq.pl.ElementToFilterValue = func(element interface{}) string {
+		return element.(*backend.FileEntry).Name
+	}
+	pq.pl.ElementPrintValue = pq_elementprintvalue_func(pq)
+	return pq
+}
+
+func pq_elementprintvalue_func(pq *PlayQueue) func(interface{}, int, int, int, bool) {
+	return func(element interface{}, x, y int, width int, is_highlighted bool) {
+		entry := element.(*backend.FileEntry)
+		var cs backend.ColoredScrollingString
+		cs.AppendString(entry.Name, termbox.ColorGreen)
+		if pq.is_in_player(entry) {
+			cs.AppendString(" (in player)", termbox.ColorYellow)
+		}
+		cs.Print(x, y, width, false, is_highlighted, 0)
+	}
+}
+
+func (pq *PlayQueue) Len() int {
+	return pq.files.Len()
+}
+
+// Add queues entry, unless it is already queued.
+func (pq *PlayQueue) Add(entry *backend.FileEntry) error {
+	if entry.IsDir {
+		return errors.New("Only files can be queued")
+	}
+	for e := pq.files.Front(); e != nil; e = e.Next() {
+		if e.Value.(*backend.FileEntry).AbsPath == entry.AbsPath {
+			return fmt.Errorf("%s is already queued", entry.Name)
+		}
+	}
+	pq.files.PushBack(entry)
+	pq.refresh()
+	return nil
+}
+
+// Flush hands the queued files that aren't in the player already to the
+// player in order, and empties the queue. If that fails, the files are kept.
+// skipped is the number of files left out because the player has them.
+func (pq *PlayQueue) Flush() (skipped int, err error) {
+	var files []string
+	for e := pq.files.Front(); e != nil; e = e.Next() {
+		if entry := e.Value.(*backend.FileEntry); !pq.is_in_player(entry) {
+			files = append(files, entry.AbsPath)
+		} else {
+			skipped++
+		}
+	}
+	if err = media_player.GlobalProfiles.QueueFiles(files); err != nil {
+		return 0, err
+	}
+	pq.files.Init()
+	pq.refresh()
+	return skipped, nil
+}
+
+// Sync reads the playlist of the player, if it can tell.
+func (pq *PlayQueue) Sync() error {
+	pq.in_player = make(map[string]bool)
+	pq.titles_in_player = make(map[string]bool)
+	reader, ok := media_player.CurrentMediaPlayer().(media_player.PlaylistReader)
+	if !ok {
+		return nil
+	}
+	playlist, err := reader.PlayerPlaylist()
+	if err == media_player.ErrNoSessionVLC {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range playlist {
+		if filepath.IsAbs(entry) {
+			pq.in_player[filepath.Clean(entry)] = true
+		} else {
+			pq.titles_in_player[strings.ToLower(entry)] = true
+		}
+	}
+	return nil
+}
+
+func (pq *PlayQueue) is_in_player(entry *backend.FileEntry) bool {
+	return pq.in_player[filepath.Clean(entry.AbsPath)] || pq.titles_in_player[strings.ToLower(entry.Name)]
+}
+
+// refresh updates the visible items after the queue has changed.
+func (pq *PlayQueue) refresh() {
+	if pq.files.Len() == 0 {
+		pq.pl.items = list.List{}
+		pq.pl.highlighted_element = nil
+		return
+	}
+	pq.pl.UpdateFilter(&pq.files, "")
+}
+
+func (pq *PlayQueue) highlighted_file() *list.Element {
+	selected, ok := pq.pl.GetSelected()
+	if !ok {
+		return nil
+	}
+	for e := pq.files.Front(); e != nil; e = e.Next() {
+		if e.Value == selected {
+			return e
+		}
+	}
+	return nil
+}
+
+func (pq *PlayQueue) Input(event termbox.Event) (err error) {
+	if handled, err := handle_player_control(event); handled {
+		return err
+	}
+
+	switch event.Key {
+	case termbox.KeyCtrlU:
+		fallthrough
+	case termbox.KeyArrowDown:
+		pq.pl.MoveCursorDown()
+	case termbox.KeyCtrlI:
+		fallthrough
+	case termbox.KeyArrowUp:
+		pq.pl.MoveCursorUp()
+	case termbox.KeyPgup:
+		if e := pq.highlighted_file(); e != nil && e.Prev() != nil {
+			pq.files.MoveBefore(e, e.Prev())
+		}
+	case termbox.KeyPgdn:
+		if e := pq.highlighted_file(); e != nil && e.Next() != nil {
+			pq.files.MoveAfter(e, e.Next())
+		}
+	case termbox.KeyCtrlD:
+		fallthrough
+	case termbox.KeyDelete:
+		if e := pq.highlighted_file(); e != nil {
+			pq.pl.MoveCursorDown()
+			if pq.pl.highlighted_element.Value == e.Value {
+				pq.pl.MoveCursorUp()
+			}
+			pq.files.Remove(e)
+		}
+	case termbox.KeyCtrlX:
+		pq.files.Init()
+	case termbox.KeyF5:
+		err = pq.Sync()
+	}
+
+	pq.refresh()
+	return
+}
+
+func (pq *PlayQueue) Finalize() IRStatus {
+	skipped, err := pq.Flush()
+	if err == nil && skipped > 0 {
+		err = Notice(fmt.Sprintf("%d queued files were already in the player's playlist and were not added again", skipped))
+	}
+	return IRStatus{true, err}
+}
+
+func (pq *PlayQueue) HandleEscape() bool {
+	return false
+}
+
+func (pq *PlayQueue) Deactivate() error {
+	PlayQueueIsOpen = false
+	return nil
+}
+
+// Activate prepares the queue for being shown.
+func (pq *PlayQueue) Activate(width, height int) error {
+	PlayQueueIsOpen = true
+	pq.Resize(width, height)
+	pq.refresh()
+	return pq.Sync()
+}
+
+func (pq *PlayQueue) Draw(is_focused bool) error {
+	pq.pl.header = fmt.Sprintf(
+		"Play queue, %d files (Enter: play, PgUp/PgDn: move, Del: remove, ctrl+x: clear, F5: sync)",
+		pq.files.Len())
+	pq.pl.PrintListing()
+	return nil
+}
+
+func (pq *PlayQueue) Resize(width, height int) error {
+	pq.pl.width = width
+	pq.pl.height = height
+	return nil
+}
+
+func (pq *PlayQueue) SetFinalizeCallback(callback func(string) error) {
+	// Finalizing flushes the queue
+}
+
+func (pq *PlayQueue) GetPrintableListing() *PrintableListing {
+	return &pq.pl
+}
+
+func (pq *PlayQueue) SelectedEntry() (*backend.FileEntry, error) {
+	return selected_entry(&pq.pl)
+}
+
+func enqueue_selected(pl *PrintableListing) error {
+	entry, err := selected_entry(pl)
+	if err != nil {
+		return err
+	}
+	return GlobalPlayQueue.Add(entry)
+}
@@ -0,0 +1,154 @@
+package gadgets
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chrigrah/nextplz/backend"
+	"github.com/chrigrah/nextplz/media_player"
+	"github.com/chrigrah/nextplz/util"
+	"github.com/nsf/termbox-go"
+	"path/filepath"
+)
+
+const (
+	RESUME_ASK    = "ask"
+	RESUME_ALWAYS = "always"
+	RESUME_NEVER  = "never"
+
+	ADVANCE_COUNTDOWN = "countdown"
+	ADVANCE_PLAY      = "play"
+	ADVANCE_OFF       = "off"
+)
+
+var (
+	ResumeMode     string = RESUME_ASK
+	WatchedPercent int    = 90
+	SkipWatched    bool   = true
+
+	AutoAdvance      string = ADVANCE_COUNTDOWN
+	AdvanceCountdown int    = 10 // Seconds
+)
+
+// play_file plays file with the player the profile rules select for it. If
+// playback of file was stopped halfway last time, it is resumed from there
+// according to ResumeMode, asking first if need be, in a box that fits in
+// width and height.
+func play_file(file string, width, height int) error {
+	profiles := media_player.GlobalProfiles
+	position := backend.GlobalState.ResumePosition(file)
+	if position == 0 || ResumeMode == RESUME_NEVER || !profiles.CanStartAt(file) {
+		return profiles.PlayFile(file)
+	} else if ResumeMode == RESUME_ALWAYS || PushGadget == nil {
+		return profiles.PlayFileAt(file, position)
+	}
+
+	resume_choice := fmt.Sprintf("Resume at %s", util.FormatSeconds(position))
+	cb, err := CreateChoiceBox(
+		fmt.Sprintf("%s was stopped halfway:", filepath.Base(file)),
+		[]string{resume_choice, "Start over"}, width, height)
+	if err != nil {
+		return err
+	}
+	cb.FinalizeCallback = func(choice string) error {
+		if choice == resume_choice {
+			return profiles.PlayFileAt(file, position)
+		}
+		return profiles.PlayFile(file)
+	}
+	PushGadget(cb)
+	return nil
+}
+
+// play_from_here plays the highlighted video of pl followed by the videos
+// after it, in the order they are listed, as one playlist. With SkipWatched,
+// watched videos after the highlighted one are left out.
+func play_from_here(pl *PrintableListing) error {
+	if pl.highlighted_element == nil {
+		return errors.New("Could not play file: Invalid selection")
+	}
+	first := pl.highlighted_element.Value.(*backend.FileEntry)
+	if first.IsDir || !backend.IsVideo(first.Name) {
+		return errors.New("Playing from here starts at a video")
+	}
+
+	files := []string{first.AbsPath}
+	for e := pl.highlighted_element.Next(); e != nil; e = e.Next() {
+		entry := e.Value.(*backend.FileEntry)
+		if entry.IsDir || !backend.IsVideo(entry.Name) {
+			continue
+		}
+		if SkipWatched && is_watched(entry) {
+			continue
+		}
+		files = append(files, entry.AbsPath)
+	}
+	return media_player.GlobalProfiles.PlayFiles(files)
+}
+
+// AdvanceFrom is meant to be called when file was played to its end and the
+// player has nothing more to play. The next episode after file is then
+// played right away or after a countdown, according to AutoAdvance. Popups
+// fit in width and height.
+func AdvanceFrom(file string, width, height int, update_chan chan int) error {
+	if AutoAdvance == ADVANCE_OFF || CountdownIsOpen {
+		return nil
+	}
+	next := backend.NextEpisode(file)
+	if next == "" {
+		return nil
+	}
+	if AutoAdvance == ADVANCE_PLAY || AdvanceCountdown <= 0 || PushGadget == nil {
+		return play_file(next, width, height)
+	}
+
+	cd := CreateCountdown(fmt.Sprintf("Next: %s", filepath.Base(next)), next, AdvanceCountdown, width, height, update_chan)
+	cd.FinalizeCallback = func(file string) error { return play_file(file, width, height) }
+	PushGadget(cd)
+	return nil
+}
+
+// RecordPlaybackStop is meant as the OnStop callback of a
+// media_player.PlaybackTracker. It remembers where playback stopped, or that
+// the file was watched if playback got far enough.
+func RecordPlaybackStop(last media_player.PlaybackStatus) error {
+	if last.Finished() || (last.Length > 0 && last.Position*100 >= last.Length*WatchedPercent) {
+		return backend.GlobalState.SetWatched(last.File, true)
+	}
+	return backend.GlobalState.SetPosition(last.File, last.Position, last.Length)
+}
+
+func toggle_watched(pl *PrintableListing) error {
+	entry, err := selected_entry(pl)
+	if err != nil {
+		return err
+	}
+	if entry.IsDir {
+		return errors.New("Only files can be marked as watched")
+	}
+	return backend.GlobalState.ToggleWatched(entry.AbsPath)
+}
+
+// name_color dims the names of watched files.
+func name_color(entry *backend.FileEntry, fg termbox.Attribute) termbox.Attribute {
+	if !entry.IsDir && is_watched(entry) {
+		return termbox.ColorBlue
+	}
+	return fg
+}
+
+func is_watched(entry *backend.FileEntry) bool {
+	state, _ := backend.GlobalState.GetEntry(entry)
+	return state.Watched
+}
+
+func append_state_markers(cs *backend.ColoredScrollingString, entry *backend.FileEntry) {
+	if entry.IsDir {
+		return
+	}
+	state, _ := backend.GlobalState.GetEntry(entry)
+	if state.Watched {
+		cs.AppendString(" *", termbox.ColorBlue)
+	} else if state.Position > 0 {
+		cs.AppendString(fmt.Sprintf(" [%s]", util.FormatSeconds(state.Position)), termbox.ColorYellow)
+	}
+}
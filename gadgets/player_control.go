@@ -0,0 +1,54 @@
+package gadgets
+
+import (
+	"github.com/chrigrah/nextplz/media_player"
+	"github.com/nsf/termbox-go"
+)
+
+const (
+	SEEK_STEP   int = 10
+	VOLUME_STEP int = 5
+)
+
+type player_key_binding struct {
+	action media_player.Capability
+	run    func(media_player.PlayerControl) error
+}
+
+var player_key_bindings = map[termbox.Key]player_key_binding{
+	termbox.KeyF6: {media_player.CapStop, media_player.PlayerControl.Stop},
+	termbox.KeyF7: {media_player.CapSeek, func(pc media_player.PlayerControl) error {
+		return pc.Seek(-SEEK_STEP)
+	}},
+	termbox.KeyF8: {media_player.CapSeek, func(pc media_player.PlayerControl) error {
+		return pc.Seek(SEEK_STEP)
+	}},
+	termbox.KeyF9:  {media_player.CapPrevious, media_player.PlayerControl.Previous},
+	termbox.KeyF10: {media_player.CapNext, media_player.PlayerControl.Next},
+	termbox.KeyF11: {media_player.CapVolume, func(pc media_player.PlayerControl) error {
+		return pc.ChangeVolume(-VOLUME_STEP)
+	}},
+	termbox.KeyF12: {media_player.CapVolume, func(pc media_player.PlayerControl) error {
+		return pc.ChangeVolume(VOLUME_STEP)
+	}},
+	termbox.KeyCtrlF: {media_player.CapFullscreen, media_player.PlayerControl.ToggleFullscreen},
+}
+
+// handle_player_control runs the player action bound to event, if any. An
+// action the current player lacks results in a *NotSupportedError rather than
+// the key being silently ignored.
+func handle_player_control(event termbox.Event) (handled bool, err error) {
+	if event.Type != termbox.EventKey || event.Ch != 0 {
+		return false, nil
+	}
+	binding, ok := player_key_bindings[event.Key]
+	if !ok {
+		return false, nil
+	}
+
+	control, err := media_player.GetControl(media_player.CurrentMediaPlayer(), binding.action)
+	if err != nil {
+		return true, err
+	}
+	return true, binding.run(control)
+}
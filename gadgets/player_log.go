@@ -0,0 +1,169 @@
+package gadgets
+
+import (
+	"fmt"
+	"github.com/chrigrah/nextplz/media_player"
+	"github.com/chrigrah/nextplz/util"
+	"github.com/nsf/termbox-go"
+	"strings"
+	"time"
+)
+
+var (
+	PlayerLogIsOpen bool = false
+)
+
+// PlayerLogView shows the output of the players, the command lines they were
+// started with and the commands sent to them, newest at the bottom. It
+// follows new lines unless scrolled up.
+type PlayerLogView struct {
+	X, Y          int
+	Width, Height int
+
+	lines   []log_line
+	scroll  int // Lines scrolled up from the bottom
+	stop    chan struct{}
+	stopped bool
+}
+
+type log_line struct {
+	text string
+	fg   termbox.Attribute
+}
+
+// CreatePlayerLog opens the log. update_chan is poked every second, so that
+// new lines show up.
+func CreatePlayerLog(x, y, width, height int, update_chan chan int) *PlayerLogView {
+	lv := &PlayerLogView{X: x, Y: y, Width: width, Height: height, stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case update_chan <- 1:
+				default: // An update is already pending
+				}
+			case <-lv.stop:
+				return
+			}
+		}
+	}()
+	PlayerLogIsOpen = true
+	return lv
+}
+
+func (lv *PlayerLogView) Input(event termbox.Event) error {
+	page := util.Max(lv.Height-2, 1)
+	switch event.Key {
+	case termbox.KeyCtrlI:
+		fallthrough
+	case termbox.KeyArrowUp:
+		lv.scroll++
+	case termbox.KeyCtrlU:
+		fallthrough
+	case termbox.KeyArrowDown:
+		lv.scroll--
+	case termbox.KeyPgup:
+		lv.scroll += page
+	case termbox.KeyPgdn:
+		lv.scroll -= page
+	case termbox.KeyHome:
+		lv.scroll = len(lv.lines)
+	case termbox.KeyEnd:
+		lv.scroll = 0
+	}
+	lv.clamp_scroll()
+	return nil
+}
+
+func (lv *PlayerLogView) SetFinalizeCallback(callback func(string) error) {
+}
+
+func (lv *PlayerLogView) Finalize() IRStatus {
+	return IRStatus{false, nil}
+}
+
+func (lv *PlayerLogView) HandleEscape() bool {
+	return false
+}
+
+func (lv *PlayerLogView) Deactivate() error {
+	if !lv.stopped {
+		close(lv.stop)
+		lv.stopped = true
+	}
+	PlayerLogIsOpen = false
+	return nil
+}
+
+func (lv *PlayerLogView) Draw(is_focused bool) error {
+	lv.lines = lv.lines[:0]
+	for _, entry := range media_player.GlobalSupervisor.LogEntries() {
+		text := fmt.Sprintf("%s %s: %s", entry.Time.Format("15:04:05"), entry.Source, entry.Text)
+		fg := log_line_color(entry.Text)
+		for _, wrapped := range wrap_line(text, lv.Width) {
+			lv.lines = append(lv.lines, log_line{wrapped, fg})
+		}
+	}
+	lv.clamp_scroll()
+
+	header := "Player log (Up/Down/PgUp/PgDn/Home/End: scroll, Esc: close)"
+	if lv.scroll > 0 {
+		header = fmt.Sprintf("Player log, %d lines below (Up/Down/PgUp/PgDn/Home/End: scroll, Esc: close)", lv.scroll)
+	}
+	util.WriteString(lv.X, lv.Y, lv.Width, termbox.ColorWhite, termbox.ColorBlue, header)
+
+	rows := lv.Height - 1
+	first := util.Max(len(lv.lines)-rows-lv.scroll, 0)
+	for row := 0; row < rows; row++ {
+		if first+row < len(lv.lines) {
+			line := lv.lines[first+row]
+			util.WriteString(lv.X, lv.Y+1+row, lv.Width, line.fg, termbox.ColorBlack, line.text)
+		} else {
+			util.FillLineTo(lv.X, lv.Y+1+row, lv.X+lv.Width, termbox.ColorBlack)
+		}
+	}
+	return nil
+}
+
+func (lv *PlayerLogView) Resize(width, height int) error {
+	lv.Width = width
+	lv.Height = height
+	return nil
+}
+
+func (lv *PlayerLogView) clamp_scroll() {
+	lv.scroll = util.Min(lv.scroll, len(lv.lines)-(lv.Height-1))
+	lv.scroll = util.Max(lv.scroll, 0)
+}
+
+// log_line_color tells command lines, commands, replies and errors apart
+// from the output of the players.
+func log_line_color(text string) termbox.Attribute {
+	switch {
+	case strings.HasPrefix(text, "$ "):
+		return termbox.ColorYellow
+	case strings.HasPrefix(text, "> "):
+		return termbox.ColorGreen
+	case strings.HasPrefix(text, "< "):
+		return termbox.ColorCyan
+	case strings.HasPrefix(text, "! "):
+		return termbox.ColorRed
+	}
+	return termbox.ColorWhite
+}
+
+// wrap_line splits text into lines of at most width characters.
+func wrap_line(text string, width int) (lines []string) {
+	runes := []rune(text)
+	if width <= 0 {
+		return []string{text}
+	}
+	for len(runes) > width {
+		lines = append(lines, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(lines, string(runes))
+}
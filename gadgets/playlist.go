@@ -0,0 +1,57 @@
+package gadgets
+
+import (
+	"errors"
+	"github.com/chrigrah/nextplz/backend"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// Whether exported playlists refer to files relative to the playlist
+	// rather than by absolute path.
+	RelativePlaylistPaths bool = true
+)
+
+// listed_files returns the paths of the files visible in pl, in the order
+// they are shown. Directories are left out.
+func listed_files(pl *PrintableListing) (files []string) {
+	for e := pl.items.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*backend.FileEntry)
+		if !entry.IsDir {
+			files = append(files, entry.AbsPath)
+		}
+	}
+	return
+}
+
+// export_playlist asks for a file name and writes the files visible in pl to
+// a playlist with that name. Relative names are relative to dir.
+func export_playlist(pl *PrintableListing, dir string) error {
+	if TextBoxIsOpen {
+		return nil
+	}
+	files := listed_files(pl)
+	if len(files) == 0 {
+		return errors.New("No files to export")
+	}
+
+	tb, err := CreateTextBox("Export playlist as (.m3u, .m3u8, .pls or .xspf):", pl.width, pl.height)
+	if err != nil {
+		return err
+	}
+	tb.X = pl.startx + pl.width/2 - tb.Width/2
+	tb.Y = pl.starty + pl.height/2 - tb.Height/2
+	tb.FinalizeCallback = func(name string) error {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return errors.New("No playlist name given")
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		return backend.WritePlaylist(name, files, RelativePlaylistPaths)
+	}
+	PushGadget(tb)
+	return nil
+}
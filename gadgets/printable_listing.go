@@ -183,6 +183,9 @@ func (pl *PrintableListing) get_highlighted_entry(superset *list.List) (result i
 }
 
 func (pl *PrintableListing) GetSelected() (selected interface{}, ok bool) {
+	if pl.highlighted_element == nil {
+		return nil, false
+	}
 	return pl.highlighted_element.Value, true
 }
 
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/chrigrah/nextplz/backend"
-	"github.com/chrigrah/nextplz/media_player"
 	"github.com/nsf/termbox-go"
 	"os"
 	"path/filepath"
@@ -29,12 +28,69 @@ type RecursiveListing struct {
 	lock        sync.Mutex
 	CL          CommandLine
 
-	tick_id uint
+	// Relative paths of exported playlists are relative to dir
+	dir string
+
+	title string
+	sort  backend.SortMode
+
+	tick_id          uint
+	state_generation uint
+	media_generation uint
 
 	current_coloredstrings map[*backend.FileEntry]*backend.ColoredScrollingString
 }
 
 func InitRecursiveFromDirectory(dl *DirectoryListing, update_chan chan int) *RecursiveListing {
+	rl := new_file_listing(dl, update_chan, dl.current_dir.AbsPath)
+	rl.title = fmt.Sprintf("Recursive listing of %s", dl.current_dir.AbsPath)
+
+	// Start dat funky recursion
+	go func() {
+		filepath.Walk(dl.current_dir.AbsPath, rl.get_walk_func())
+		// Sorting as the files come in would compare each file to all found
+		// before it, which is slow for big folders, so they are sorted once
+		rl.lock.Lock()
+		backend.SortEntries(&rl.video_files, rl.sort)
+		rl.lock.Unlock()
+		rl.update_chan <- 1
+	}()
+
+	return rl
+}
+
+// InitPlaylistListing lists the files of the playlist at path, in the order
+// of the playlist.
+func InitPlaylistListing(dl *DirectoryListing, update_chan chan int, path string) (*RecursiveListing, error) {
+	files, err := backend.ReadPlaylist(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := new_file_listing(dl, update_chan, filepath.Dir(path))
+	rl.title = fmt.Sprintf("Playlist %s (%d files)", path, len(files))
+	// In the order of the playlist until sorted otherwise
+	rl.sort = backend.SortMode{}
+	for _, file := range files {
+		info, stat_err := os.Stat(file)
+		entry := &backend.FileEntry{
+			Name:         filepath.Base(file),
+			AbsPath:      file,
+			IsDir:        false,
+			IsAccessible: stat_err == nil,
+			IsVideo:      backend.IsVideo(filepath.Base(file)),
+		}
+		if stat_err == nil {
+			entry.SetInfo(info)
+		}
+		rl.video_files.PushBack(entry)
+	}
+	rl.pl.UpdateFilter(&rl.video_files, string(rl.CL.Cmd))
+
+	return rl, nil
+}
+
+func new_file_listing(dl *DirectoryListing, update_chan chan int, dir string) *RecursiveListing {
 	var rl RecursiveListing
 	rl.pl = PrintableListing{
 		column_width: 80,
@@ -46,8 +102,9 @@ func InitRecursiveFromDirectory(dl *DirectoryListing, update_chan chan int) *Rec
 	rl.current_coloredstrings = make(map[*backend.FileEntry]*backend.ColoredScrollingString)
 	rl.pl.ElementToFilterValue = rl_elementtofiltervalue_func()
 	rl.pl.ElementPrintValue = rl_elementprintvalue_func(&rl)
-	rl.pl.header = fmt.Sprintf("Recursive listing of %s", dl.current_dir.AbsPath)
 	rl.update_chan = update_chan
+	rl.dir = dir
+	rl.sort = dl.sort
 
 	rl.CL.X = rl.pl.startx
 	rl.CL.Y = rl.pl.starty + rl.pl.height
@@ -60,11 +117,6 @@ func InitRecursiveFromDirectory(dl *DirectoryListing, update_chan chan int) *Rec
 
 	rl.pl.UpdateFilter(&rl.video_files, string(rl.CL.Cmd))
 
-	// Start dat funky recursion
-	go func() {
-		filepath.Walk(dl.current_dir.AbsPath, rl.get_walk_func())
-	}()
-
 	go func() {
 		ticker := time.Tick(250 * time.Millisecond)
 		for _ = range ticker {
@@ -103,7 +155,11 @@ func rl_elementprintvalue_func(rl *RecursiveListing) func(interface{}, int, int,
 
 func rl_fe_to_coloredstring(entry *backend.FileEntry) (cs *backend.ColoredScrollingString) {
 	cs = &backend.ColoredScrollingString{}
-	cs.AppendString(entry.Name, termbox.ColorGreen)
+	if entry.IsAccessible {
+		cs.AppendString(entry.Name, name_color(entry, termbox.ColorGreen))
+	} else {
+		cs.AppendString(entry.Name, termbox.ColorRed)
+	}
 
 	if EnableFoldersForRars && strings.HasSuffix(entry.Name, ".rar") {
 		cs.AppendString(" (", termbox.ColorWhite)
@@ -111,6 +167,9 @@ func rl_fe_to_coloredstring(entry *backend.FileEntry) (cs *backend.ColoredScroll
 		cs.AppendString(top_folder, termbox.ColorCyan)
 		cs.AppendString(")", termbox.ColorWhite)
 	}
+	append_subtitle_marker(cs, entry)
+	append_state_markers(cs, entry)
+	append_media_info(cs, entry)
 	return
 }
 
@@ -124,6 +183,14 @@ func rl_fe_get_top_folder(entry *backend.FileEntry) (top_folder string) {
 }
 
 func (rl *RecursiveListing) Input(event termbox.Event) (err error) {
+	if handled, err := handle_player_control(event); handled {
+		return err
+	}
+
+	// The files are still being added to while the directory is walked
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
 	switch event.Key {
 	case termbox.KeyCtrlY:
 		rl.pl.MoveCursorLeft()
@@ -137,11 +204,22 @@ func (rl *RecursiveListing) Input(event termbox.Event) (err error) {
 		rl.pl.MoveCursorUp()
 	case termbox.KeyCtrlO:
 		rl.pl.MoveCursorRight()
+	case termbox.KeyCtrlR, termbox.KeyCtrlG, termbox.KeyCtrlD:
+		rl.sort = change_sort(rl.sort, event.Key)
+		backend.SortEntries(&rl.video_files, rl.sort)
+	case termbox.KeyCtrlT:
+		err = toggle_watched(&rl.pl)
+	case termbox.KeyCtrlE:
+		err = enqueue_selected(&rl.pl)
+	case termbox.KeyCtrlA:
+		err = play_from_here(&rl.pl)
+	case termbox.KeyCtrlS:
+		err = export_playlist(&rl.pl, rl.dir)
 	case termbox.KeyCtrlB:
 		file, ok := rl.pl.GetSelected()
 		if ok {
 			file_str := file.(*backend.FileEntry).AbsPath
-			media_player.GlobalMediaPlayer.PlayFile(file_str)
+			err = play_file(file_str, rl.pl.width, rl.pl.height+1)
 		} else {
 			err = errors.New(fmt.Sprintf("Could not play file: Invalid selection"))
 		}
@@ -176,6 +254,13 @@ func (rl *RecursiveListing) Draw(is_focused bool) error {
 	rl.lock.Lock()
 	defer rl.lock.Unlock()
 
+	state_generation, media_generation := backend.GlobalState.Generation(), backend.GlobalMediaInfo.Generation()
+	if state_generation != rl.state_generation || media_generation != rl.media_generation {
+		rl.current_coloredstrings = make(map[*backend.FileEntry]*backend.ColoredScrollingString)
+		rl.state_generation, rl.media_generation = state_generation, media_generation
+	}
+
+	rl.pl.header = fmt.Sprintf("%s [%s]", rl.title, rl.sort)
 	rl.pl.UpdateFilter(&rl.video_files, string(rl.CL.Cmd))
 	rl.pl.PrintListing()
 
@@ -200,6 +285,10 @@ func (rl *RecursiveListing) GetPrintableListing() *PrintableListing {
 	return &rl.pl
 }
 
+func (rl *RecursiveListing) SelectedEntry() (*backend.FileEntry, error) {
+	return selected_entry(&rl.pl)
+}
+
 func (rl *RecursiveListing) get_walk_func() filepath.WalkFunc {
 	return filepath.WalkFunc(
 		func(path string, info os.FileInfo, err error) error {
@@ -213,6 +302,7 @@ func (rl *RecursiveListing) get_walk_func() filepath.WalkFunc {
 					IsAccessible: true,
 					IsVideo:      true,
 				}
+				new_file.SetInfo(info)
 
 				rl.video_files.PushBack(&new_file)
 				rl.lock.Unlock()
@@ -27,3 +27,11 @@ func (sl *StatusLine) ShowError(err error) {
 	sl.FG = termbox.ColorWhite
 	sl.BG = termbox.ColorRed
 }
+
+// Notice is returned as an error by gadgets to tell the user something that
+// isn't a failure. It is shown like an update rather than an error.
+type Notice string
+
+func (n Notice) Error() string {
+	return string(n)
+}
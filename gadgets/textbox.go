@@ -26,6 +26,9 @@ type InputReceiver interface {
 
 var (
 	TextBoxIsOpen bool = false
+
+	// PushGadget shows a gadget on top of the focused one. Set by main.
+	PushGadget func(InputReceiver)
 )
 
 type TextBox struct {
@@ -130,19 +133,27 @@ func (tb *TextBox) Resize(width, height int) error {
 }
 
 func (tb *TextBox) draw_borders() {
-	termbox.SetCell(tb.X, tb.Y, '+', termbox.ColorWhite, termbox.ColorBlue)
-	termbox.SetCell(tb.X+tb.Width-1, tb.Y, '+', termbox.ColorWhite, termbox.ColorBlue)
-	termbox.SetCell(tb.X, tb.Y+tb.Height-1, '+', termbox.ColorWhite, termbox.ColorBlue)
-	termbox.SetCell(tb.X+tb.Width-1, tb.Y+tb.Height-1, '+', termbox.ColorWhite, termbox.ColorBlue)
-	util.RepeatCharX(tb.X+1, tb.X+tb.Width-1, tb.Y, '-', termbox.ColorWhite, termbox.ColorBlue)
-	util.RepeatCharX(tb.X+1, tb.X+tb.Width-1, tb.Y+tb.Height-1, '-', termbox.ColorWhite, termbox.ColorBlue)
-	util.RepeatCharY(tb.Y+1, tb.Y+tb.Height-1, tb.X, '|', termbox.ColorWhite, termbox.ColorBlue)
-	util.RepeatCharY(tb.Y+1, tb.Y+tb.Height-1, tb.X+tb.Width-1, '|', termbox.ColorWhite, termbox.ColorBlue)
+	draw_box(tb.X, tb.Y, tb.Width, tb.Height)
 }
 
 func (tb *TextBox) fill() {
-	for y := tb.Y + 1; y < tb.Y+tb.Height-1; y++ {
-		util.FillLineTo(tb.X+1, y, tb.X+tb.Width-1, termbox.ColorBlue)
+	fill_box(tb.X, tb.Y, tb.Width, tb.Height)
+}
+
+func draw_box(x, y, width, height int) {
+	termbox.SetCell(x, y, '+', termbox.ColorWhite, termbox.ColorBlue)
+	termbox.SetCell(x+width-1, y, '+', termbox.ColorWhite, termbox.ColorBlue)
+	termbox.SetCell(x, y+height-1, '+', termbox.ColorWhite, termbox.ColorBlue)
+	termbox.SetCell(x+width-1, y+height-1, '+', termbox.ColorWhite, termbox.ColorBlue)
+	util.RepeatCharX(x+1, x+width-1, y, '-', termbox.ColorWhite, termbox.ColorBlue)
+	util.RepeatCharX(x+1, x+width-1, y+height-1, '-', termbox.ColorWhite, termbox.ColorBlue)
+	util.RepeatCharY(y+1, y+height-1, x, '|', termbox.ColorWhite, termbox.ColorBlue)
+	util.RepeatCharY(y+1, y+height-1, x+width-1, '|', termbox.ColorWhite, termbox.ColorBlue)
+}
+
+func fill_box(x, y, width, height int) {
+	for at_y := y + 1; at_y < y+height-1; at_y++ {
+		util.FillLineTo(x+1, at_y, x+width-1, termbox.ColorBlue)
 	}
 }
 
@@ -10,6 +10,7 @@ import (
 	"github.com/nsf/termbox-go"
 	"os"
 	"strings"
+	"time"
 )
 
 var (
@@ -17,15 +18,29 @@ var (
 	dl            *gadgets.DirectoryListing
 	sl            gadgets.StatusLine
 	at_state      int
-	events        chan termbox.Event = make(chan termbox.Event, 10)
-	update_chan   chan int           = make(chan int, 10)
+	events        chan termbox.Event               = make(chan termbox.Event, 10)
+	update_chan   chan int                         = make(chan int, 10)
+	error_chan    chan error                       = make(chan error, 10)
+	ended_chan    chan media_player.PlaybackStatus = make(chan media_player.PlaybackStatus, 10)
 	focus_stack   *list.List
 
 	media_extensions string
+	subtitle_langs   string
+	profiles_path    string
+	state_path       string
+	media_info_path  string
+	startup_error    error
 )
 
 func main() {
 	var err error
+	defer func() {
+		// Runs after termbox.Close so that the message ends up on a sane terminal
+		if startup_error != nil {
+			fmt.Fprintf(os.Stderr, "nextplz: %s\n", startup_error)
+			os.Exit(2)
+		}
+	}()
 	err = termbox.Init()
 	if err != nil {
 		panic(err)
@@ -39,6 +54,8 @@ func main() {
 	if !success {
 		return
 	}
+	defer media_player.GlobalSupervisor.Shutdown()
+	defer backend.GlobalMediaInfo.Save()
 
 	go feed_events()
 
@@ -76,15 +93,7 @@ func main() {
 						}
 					}
 				case termbox.KeyEnter:
-					status := focus_stack.Front().Value.(gadgets.InputReceiver).Finalize()
-
-					if status.Done {
-						err = focus_stack.Front().Value.(gadgets.InputReceiver).Deactivate()
-						focus_stack.Remove(focus_stack.Front())
-					}
-					if status.Chain != nil {
-						err = status.Chain
-					}
+					finalize_focused()
 				case termbox.KeyF3:
 					if !gadgets.TextBoxIsOpen {
 						tb, err := gadgets.CreateTextBox("Change directory:", width, height)
@@ -97,11 +106,34 @@ func main() {
 						tb.FinalizeCallback = func(dir string) error { return dl.ChangeDirectory(dir) }
 						focus_stack.PushFront(tb)
 					}
+				case termbox.KeyCtrlW:
+					selector, ok := focus_stack.Front().Value.(gadgets.EntrySelector)
+					if ok && !gadgets.ChoiceBoxIsOpen {
+						cb, err := gadgets.CreateOpenWithBox(selector, width, height)
+						if err != nil {
+							display_error(err)
+							continue
+						}
+						focus_stack.PushFront(cb)
+					}
+				case termbox.KeyF2:
+					if !gadgets.PlayQueueIsOpen {
+						display_error(gadgets.GlobalPlayQueue.Activate(width, height-1))
+						focus_stack.PushFront(gadgets.GlobalPlayQueue)
+					}
+				case termbox.KeyCtrlL:
+					if !gadgets.PlayerLogIsOpen {
+						focus_stack.PushFront(gadgets.CreatePlayerLog(0, 0, width, height-1, update_chan))
+					}
 				case termbox.KeyF4:
 					rl := gadgets.InitRecursiveFromDirectory(dl, update_chan)
 					focus_stack.PushFront(rl)
 				case termbox.KeyCtrlSpace:
-					err = media_player.GlobalMediaPlayer.(*media_player.VLC).Pause()
+					control, pause_err := media_player.GetControl(media_player.CurrentMediaPlayer(), media_player.CapPause)
+					if pause_err == nil {
+						pause_err = control.Pause()
+					}
+					display_error(pause_err)
 				}
 			}
 
@@ -110,12 +142,38 @@ func main() {
 			display_error(err)
 			update()
 
+		case err := <-error_chan:
+			display_error(err)
+			update()
+
+		case last := <-ended_chan:
+			display_error(gadgets.AdvanceFrom(last.File, width, height-1, update_chan))
+			update()
+
 		case <-update_chan:
+			for _, err := range media_player.GlobalSupervisor.PopFailures() {
+				display_error(err)
+			}
+			if expirer, ok := focus_stack.Front().Value.(gadgets.Expirer); ok && expirer.Expired() {
+				finalize_focused()
+			}
 			update()
 		}
 	}
 }
 
+// finalize_focused finalizes the focused gadget and removes it if it is done.
+// Finalizing may push another gadget, so the one finalized is remembered.
+func finalize_focused() {
+	focused := focus_stack.Front()
+	status := focused.Value.(gadgets.InputReceiver).Finalize()
+	if status.Done {
+		display_error(focused.Value.(gadgets.InputReceiver).Deactivate())
+		focus_stack.Remove(focused)
+	}
+	display_error(status.Chain)
+}
+
 func feed_events() {
 	for {
 		events <- termbox.PollEvent()
@@ -127,6 +185,36 @@ func initialize_globals() (success bool) {
 	mp_info := media_player.InitMediaPlayerFlagParser(flagset)
 	flagset.StringVar(&media_extensions, "extensions", ".avi,.mkv,.mpg,.wmv",
 		"Comma separated list of file extensions that should be considered video files.\n")
+	flagset.BoolVar(&backend.AutoSubtitles, "auto-subs", true,
+		"If set to true, subtitles found next to videos or in Subs folders are passed to the media player.\n")
+	flagset.StringVar(&subtitle_langs, "sub-langs", "",
+		"Comma separated list of preferred subtitle languages, most preferred first, like en,sv.\n")
+	flagset.StringVar(&profiles_path, "profiles", media_player.DefaultProfilesPath(),
+		"JSON file with named media player profiles and rules for which files they play.\n")
+	flagset.StringVar(&state_path, "state", backend.DefaultStatePath(),
+		"JSON file where playback positions and watched files are remembered.\n")
+	flagset.StringVar(&backend.FFProbe, "ffprobe", "ffprobe",
+		"The ffprobe executable that durations, resolutions, codecs and languages of videos are read with. Empty turns this off.\n")
+	flagset.IntVar(&backend.MediaInfoWorkers, "ffprobe-workers", 2,
+		"How many videos ffprobe reads at the same time.\n")
+	flagset.StringVar(&media_info_path, "media-info", backend.DefaultMediaInfoPath(),
+		"JSON file where what ffprobe says about videos is remembered.\n")
+	flagset.StringVar(&gadgets.ResumeMode, "resume", gadgets.RESUME_ASK,
+		"Whether to resume files that were stopped halfway: ask, always or never.\n")
+	flagset.IntVar(&gadgets.WatchedPercent, "watched-percent", 90,
+		"Files played at least this far, in percent, are marked as watched.\n")
+	flagset.StringVar(&gadgets.AutoAdvance, "auto-advance", gadgets.ADVANCE_COUNTDOWN,
+		"What to do when a video has been played to the end: countdown to the next episode, play it right away, or off.\n")
+	flagset.IntVar(&gadgets.AdvanceCountdown, "advance-countdown", 10,
+		"Seconds to count down before the next episode is played.\n")
+	flagset.BoolVar(&gadgets.SkipWatched, "skip-watched", true,
+		"If set to true, playing from a video (ctrl+a) leaves out the watched videos after it.\n")
+	flagset.StringVar(&backend.SortBy, "sort", backend.SORT_NAME,
+		"Order of listings: name, episode, mtime, ctime, size or extension. Times and sizes sort newest and biggest first.\n")
+	flagset.BoolVar(&backend.DirsFirst, "dirs-first", false,
+		"If set to true, directories are listed before files.\n")
+	flagset.BoolVar(&gadgets.RelativePlaylistPaths, "relative-playlists", true,
+		"If set to true, exported playlists refer to files relative to the playlist.\n")
 	flagset.IntVar(&gadgets.LS_COL_WIDTH, "cw", 50, "Column width for directory listing.\n")
 	flagset.BoolVar(&backend.FilterSubs, "filter-subs", true,
 		"If set to true, rar files matching [.-]subs[.-] will be filtered out from recursive listings.")
@@ -142,14 +230,47 @@ func initialize_globals() (success bool) {
 		display_error(flagerr)
 	}
 
+	switch gadgets.ResumeMode {
+	case gadgets.RESUME_ASK, gadgets.RESUME_ALWAYS, gadgets.RESUME_NEVER:
+	default:
+		startup_error = fmt.Errorf("-resume must be ask, always or never, not %q", gadgets.ResumeMode)
+		return false
+	}
+	switch gadgets.AutoAdvance {
+	case gadgets.ADVANCE_COUNTDOWN, gadgets.ADVANCE_PLAY, gadgets.ADVANCE_OFF:
+	default:
+		startup_error = fmt.Errorf("-auto-advance must be countdown, play or off, not %q", gadgets.AutoAdvance)
+		return false
+	}
+
+	if backend.MediaInfoWorkers < 1 {
+		startup_error = fmt.Errorf("-ffprobe-workers must be at least 1, not %d", backend.MediaInfoWorkers)
+		return false
+	}
+	if !backend.IsSortOrder(backend.SortBy) {
+		startup_error = fmt.Errorf("-sort must be name, episode, mtime, ctime, size or extension, not %q", backend.SortBy)
+		return false
+	}
+
 	width, height = termbox.Size()
 	dl = gadgets.NewListing(0, 0, width, height-1, update_chan)
 
 	backend.VideoExtensions = strings.Split(media_extensions, ",")
-	var err error
-	media_player.GlobalMediaPlayer, err = mp_info.CreateMediaPlayer()
+	if subtitle_langs != "" {
+		backend.SubtitleLanguages = strings.Split(subtitle_langs, ",")
+	}
+	media_player.GlobalSupervisor = media_player.NewSupervisor(update_chan)
+	media_player.GlobalSupervisor.KillOnExit = mp_info.KillOnExit
+	default_player, err := mp_info.CreateMediaPlayer()
 	if err != nil {
-		panic(err)
+		startup_error = err
+		return false
+	}
+	media_player.SetMediaPlayer(default_player)
+	media_player.GlobalProfiles, err = media_player.LoadProfiles(profiles_path, mp_info, default_player)
+	if err != nil {
+		startup_error = err
+		return false
 	}
 
 	sl.X = 0
@@ -157,8 +278,32 @@ func initialize_globals() (success bool) {
 	sl.Length = width
 	sl.ShowUpdate("") // colors the statusline so that it's not just white
 
+	var state_err error
+	backend.GlobalState, state_err = backend.LoadStateStore(state_path)
+	display_error(state_err)
+	var media_info_err error
+	backend.GlobalMediaInfo, media_info_err = backend.LoadMediaInfoStore(media_info_path, update_chan)
+	display_error(media_info_err)
+	if backend.FFProbe != "ffprobe" && backend.FFProbe != "" && !backend.GlobalMediaInfo.Available() {
+		// Without ffprobe on the path media info is quietly left out, but one
+		// that was asked for by name should be found.
+		display_error(fmt.Errorf("Could not find ffprobe at %s", backend.FFProbe))
+	}
+
+	gadgets.GlobalPlayQueue = gadgets.NewPlayQueue(0, 0, width, height-1)
+
 	focus_stack = list.New()
 	focus_stack.PushFront(dl)
+	gadgets.PushGadget = func(ir gadgets.InputReceiver) { focus_stack.PushFront(ir) }
+
+	media_player.StartPlaybackTracker(time.Second, func(last media_player.PlaybackStatus) {
+		if err := gadgets.RecordPlaybackStop(last); err != nil {
+			error_chan <- err
+		}
+		update_chan <- 1
+	}, func(last media_player.PlaybackStatus) {
+		ended_chan <- last
+	})
 
 	return true
 }
@@ -175,7 +320,9 @@ func update() {
 }
 
 func display_error(err error) {
-	if err != nil {
+	if notice, ok := err.(gadgets.Notice); ok {
+		sl.ShowUpdate(string(notice))
+	} else if err != nil {
 		sl.ShowError(err)
 	}
 }
@@ -0,0 +1,79 @@
+package media_player
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capability is a set of control actions supported by a media player.
+type Capability uint
+
+const (
+	CapPause Capability = 1 << iota
+	CapStop
+	CapNext
+	CapPrevious
+	CapSeek
+	CapVolume
+	CapFullscreen
+)
+
+var capability_names = []struct {
+	cap  Capability
+	name string
+}{
+	{CapPause, "Pause"},
+	{CapStop, "Stop"},
+	{CapNext, "Next"},
+	{CapPrevious, "Previous"},
+	{CapSeek, "Seek"},
+	{CapVolume, "Volume"},
+	{CapFullscreen, "Fullscreen"},
+}
+
+func (c Capability) String() string {
+	var names []string
+	for _, cn := range capability_names {
+		if c&cn.cap != 0 {
+			names = append(names, cn.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// PlayerControl is implemented by media players that can be controlled
+// beyond just being handed files. Capabilities reports which of the
+// methods actually do something; the rest return a *NotSupportedError.
+type PlayerControl interface {
+	Capabilities() Capability
+
+	Pause() error
+	Stop() error
+	Next() error
+	Previous() error
+	Seek(seconds int) error
+	ChangeVolume(percent int) error
+	ToggleFullscreen() error
+}
+
+type NotSupportedError struct {
+	Action Capability
+}
+
+func (err *NotSupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by this player", err.Action)
+}
+
+func Supports(mp MediaPlayer, action Capability) bool {
+	control, ok := mp.(PlayerControl)
+	return ok && control.Capabilities()&action == action
+}
+
+// GetControl returns the PlayerControl of mp if it supports action, and a
+// *NotSupportedError otherwise.
+func GetControl(mp MediaPlayer, action Capability) (PlayerControl, error) {
+	if !Supports(mp, action) {
+		return nil, &NotSupportedError{action}
+	}
+	return mp.(PlayerControl), nil
+}
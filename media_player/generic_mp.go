@@ -3,9 +3,12 @@ package media_player
 import (
 	"flag"
 	"fmt"
-	"net"
+	"github.com/chrigrah/nextplz/backend"
+	"io/ioutil"
+	"os"
 	"os/exec"
-	"strings"
+	"path/filepath"
+	"sync"
 )
 
 type MediaPlayer interface {
@@ -15,20 +18,85 @@ type MediaPlayer interface {
 type MediaPlayerInitInfo struct {
 	Executable string
 	Arguments  string
+	Player     string
+	MPVSocket  string
+	RC         RCEndpoint
+	Kodi       KodiInfo
+	MPRIS      MPRISInfo
+	KillOnExit bool
+
+	flagset *flag.FlagSet
 }
 
-var GlobalMediaPlayer MediaPlayer
+// The player that the player controls and the PlaybackTracker act on. It is
+// changed by the UI and read by the tracker, so it is behind a lock.
+var (
+	current_player      MediaPlayer
+	current_player_lock sync.Mutex
+)
+
+// SetMediaPlayer makes mp the player that the player controls and the
+// PlaybackTracker act on.
+func SetMediaPlayer(mp MediaPlayer) {
+	current_player_lock.Lock()
+	defer current_player_lock.Unlock()
+	current_player = mp
+}
+
+// CurrentMediaPlayer returns the player set with SetMediaPlayer, which is the
+// one that last played a file.
+func CurrentMediaPlayer() MediaPlayer {
+	current_player_lock.Lock()
+	defer current_player_lock.Unlock()
+	return current_player
+}
 
 func InitMediaPlayerFlagParser(flagset *flag.FlagSet) *MediaPlayerInitInfo {
-	var info MediaPlayerInitInfo
+	info := MediaPlayerInitInfo{flagset: flagset}
 	flagset.StringVar(&info.Executable, "exe", "", "The name of the media player executable (must be on system path)")
-	flagset.StringVar(&info.Arguments, "args", "", "Arguments to be passed to the media player")
+	flagset.StringVar(&info.Arguments, "args", "",
+		"Arguments to be passed to the media player, with shell style quoting and the placeholders "+
+			known_placeholders()+". The file is passed last unless {file} or {playlist} is used")
+	flagset.StringVar(&info.Player, "player", "vlc", "The media player to control, vlc, vlc-http, mpv, mplayer, kodi or mpris (ignored if -exe is set)")
+	flagset.StringVar(&info.MPVSocket, "mpv-socket", "", "Path of the mpv IPC socket (default is per user in the temp directory)")
+	flagset.StringVar(&info.RC.Host, "rc-host", "127.0.0.1", "Host the VLC remote control or HTTP interface listens on")
+	flagset.IntVar(&info.RC.Port, "rc-port", 47246,
+		"Port of the VLC remote control or HTTP interface, 0 picks a random port for each VLC started")
+	flagset.StringVar(&info.RC.Unix, "rc-unix", "",
+		"Use a unix socket at this path for the VLC remote control interface (default is a private socket for each VLC, or TCP if -rc-host or -rc-port is set)")
+	flagset.BoolVar(&info.RC.Attach, "rc-attach", false,
+		"If set to true, files may be queued into a VLC that was not started by this nextplz session")
+	flagset.StringVar(&info.Kodi.Host, "kodi-host", "127.0.0.1:8080", "Host and port of the Kodi web server")
+	flagset.StringVar(&info.Kodi.User, "kodi-user", "kodi", "User name for the Kodi web server")
+	flagset.StringVar(&info.Kodi.Password, "kodi-password", "", "Password for the Kodi web server")
+	flagset.StringVar(&info.Kodi.PathMap, "kodi-path-map", "",
+		"Comma separated list of local=remote path prefixes, translating local paths to the paths Kodi sees")
+	flagset.StringVar(&info.MPRIS.Player, "mpris-player", "",
+		"Name of the MPRIS player to control, like vlc or celluloid (default is the first one found)")
+	flagset.StringVar(&info.MPRIS.Bus, "mpris-bus", "", "Address of the D-Bus bus to look for MPRIS players on (default is the session bus)")
+	flagset.BoolVar(&info.KillOnExit, "kill-players", false,
+		"If set to true, media players started by nextplz are terminated when nextplz exits")
 	return &info
 }
 
 func (info *MediaPlayerInitInfo) CreateMediaPlayer() (MediaPlayer, error) {
 	if info.Executable == "" {
-		return CreateDefaultMediaPlayer()
+		switch info.Player {
+		case "vlc", "":
+			return CreateVLC(info.rc_endpoint())
+		case "mpv":
+			return CreateMPV(info.MPVSocket)
+		case "vlc-http":
+			return CreateVLCHTTP(info.RC)
+		case "mplayer":
+			return CreateMPlayer()
+		case "kodi":
+			return CreateKodi(info.Kodi)
+		case "mpris":
+			return CreateMPRIS(info.MPRIS)
+		default:
+			return nil, fmt.Errorf("Unknown media player: %s", info.Player)
+		}
 	}
 
 	executable, err := exec.LookPath(info.Executable)
@@ -36,104 +104,116 @@ func (info *MediaPlayerInitInfo) CreateMediaPlayer() (MediaPlayer, error) {
 		return nil, err
 	}
 
-	var mp CustomMediaPlayer
-	mp.Executable = executable
-	if info.Arguments == "" {
-		mp.Args = make([]string, 1)
-	} else {
-		static_args := strings.Split(info.Arguments, " ")
-		mp.Args = make([]string, len(static_args)+1)
-		copy(mp.Args, static_args)
+	template, err := ParseCommandTemplate(info.Arguments)
+	if err != nil {
+		return nil, fmt.Errorf("-args: %s", err)
+	}
+	if !template.Uses("file") && !template.Uses("playlist") {
+		template.AppendPlaceholder("file")
 	}
 
-	return &mp, nil
-
+	return &CustomMediaPlayer{executable, template}, nil
 }
 
-func CreateDefaultMediaPlayer() (MediaPlayer, error) {
-	executable, err := exec.LookPath("vlc")
-	if err != nil {
-		return nil, err
+// rc_endpoint returns the endpoint for the RC interface of VLC. Unless asked
+// for something else, VLCs started by this session listen on private unix
+// sockets, which unlike TCP ports can't be taken by someone else meanwhile.
+func (info *MediaPlayerInitInfo) rc_endpoint() RCEndpoint {
+	endpoint := info.RC
+	if endpoint.Unix != "" || endpoint.Attach || !unix_sockets_work() {
+		return endpoint
+	}
+	tcp_requested := false
+	if info.flagset != nil {
+		info.flagset.Visit(func(f *flag.Flag) {
+			tcp_requested = tcp_requested || f.Name == "rc-host" || f.Name == "rc-port"
+		})
 	}
+	endpoint.Private = !tcp_requested
+	return endpoint
+}
 
-	return MediaPlayer(&VLC{executable}), nil
+func CreateDefaultMediaPlayer() (MediaPlayer, error) {
+	return CreateVLC(RCEndpoint{Host: "127.0.0.1", Port: 47246})
 }
 
 ////////////////////////////////////////////////////////////////////////
 type CustomMediaPlayer struct {
 	Executable string
-	Args       []string
+	Template   *CommandTemplate
 }
 
 func (mp *CustomMediaPlayer) PlayFile(file string) error {
-	var command exec.Cmd
-	command.Path = mp.Executable
-	mp.Args[len(mp.Args)-1] = file
-	command.Args = mp.Args
-
-	command.Start()
-	return nil
+	return mp.play(TemplateVars{File: file})
 }
 
-////////////////////////////////////////////////////////////////////////
-type VLC struct {
-	executable string
+// CanStartAt is true if the template has a {start} placeholder.
+func (mp *CustomMediaPlayer) CanStartAt() bool {
+	return mp.Template.Uses("start")
 }
 
-func (vlc *VLC) TryQueue(file string) error {
-	vlcConn, err := net.Dial("tcp", "localhost:47246")
-	if err != nil {
-		return err
-	}
-
-	result := make([]byte, 1024)
-	err = vlc_rc_exec(vlcConn, fmt.Sprintf("add %s\n", file), result)
-	if err != nil {
-		return err
-	}
-
-	vlcConn.Close()
-	return nil
+func (mp *CustomMediaPlayer) PlayFileAt(file string, start int) error {
+	return mp.play(TemplateVars{File: file, Start: start})
 }
 
-func (vlc *VLC) PlayFile(file string) error {
-	err := vlc.TryQueue(file)
-	if err == nil {
+// PlayFiles hands all of files to the player through {playlist}. Without
+// that placeholder only the first of them can be played.
+func (mp *CustomMediaPlayer) PlayFiles(files []string) error {
+	if !mp.Template.Uses("playlist") {
+		if err := mp.PlayFile(files[0]); err != nil {
+			return err
+		}
+		if len(files) > 1 {
+			return fmt.Errorf("Played only %s, -args needs {playlist} to play several files", filepath.Base(files[0]))
+		}
 		return nil
 	}
-
-	command := exec.Command(vlc.executable, "--extraintf", "rc", "--rc-host", "127.0.0.1:47246", file)
-	command.Start()
-
-	return nil
+	return mp.play(TemplateVars{File: files[0]}, files...)
 }
 
-func (vlc *VLC) Pause() error {
-	vlcConn, err := net.Dial("tcp", "localhost:47246")
-	if err != nil {
-		return err
+// play runs the player with vars. The {playlist} holds files, or just the
+// file if there are none.
+func (mp *CustomMediaPlayer) play(vars TemplateVars, files ...string) error {
+	vars.Subs = backend.PreferredSubtitle(vars.File)
+	if len(files) == 0 {
+		files = []string{vars.File}
 	}
-
-	result := make([]byte, 1024)
-	err = vlc_rc_exec(vlcConn, "pause\n", result)
-	if err != nil {
-		return err
+	if mp.Template.Uses("playlist") {
+		playlist, err := write_temp_playlist(files)
+		if err != nil {
+			return err
+		}
+		vars.Playlist = playlist
 	}
 
-	vlcConn.Close()
-	return nil
+	command := exec.Command(mp.Executable, mp.Template.Expand(vars)...)
+	process, err := GlobalSupervisor.Start(command)
+	if vars.Playlist != "" {
+		if err != nil {
+			os.Remove(vars.Playlist)
+		} else {
+			go func() {
+				<-process.Done()
+				os.Remove(vars.Playlist)
+			}()
+		}
+	}
+	return err
 }
 
-func vlc_rc_exec(rc net.Conn, cmd string, result []byte) error {
-	_, err := fmt.Fprint(rc, cmd)
+// write_temp_playlist writes files to an M3U playlist in the temp directory
+// for players that are handed a {playlist}. It is removed once the player
+// exits.
+func write_temp_playlist(files []string) (string, error) {
+	playlist, err := ioutil.TempFile("", "nextplz-*.m3u")
 	if err != nil {
-		return err
+		return "", err
 	}
+	defer playlist.Close()
 
-	if result != nil {
-		_, err = rc.Read(result)
-		return err
+	fmt.Fprintln(playlist, "#EXTM3U")
+	for _, file := range files {
+		fmt.Fprintln(playlist, file)
 	}
-
-	return nil
+	return playlist.Name(), nil
 }
@@ -0,0 +1,432 @@
+package media_player
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kodi_timeout = 5 * time.Second
+
+	// The playlist Kodi plays videos from
+	kodi_video_playlist = 1
+)
+
+var ErrKodiIdle = errors.New("Kodi is not playing anything")
+
+// KodiInfo is how to reach a Kodi with its web server enabled.
+type KodiInfo struct {
+	Host     string
+	User     string
+	Password string
+	PathMap  string
+}
+
+// Kodi plays files on a Kodi, possibly on another machine, through its
+// JSON-RPC interface over HTTP. Local paths are translated to the paths Kodi
+// sees through a PathMap.
+type Kodi struct {
+	url      string
+	user     string
+	password string
+	path_map PathMap
+	client   *http.Client
+
+	lock       sync.Mutex
+	request_id int
+}
+
+func CreateKodi(info KodiInfo) (MediaPlayer, error) {
+	path_map, err := ParsePathMap(info.PathMap)
+	if err != nil {
+		return nil, fmt.Errorf("-kodi-path-map: %s", err)
+	}
+	return MediaPlayer(NewKodi(info.Host, info.User, info.Password, path_map)), nil
+}
+
+// NewKodi creates a Kodi for the web server at host, which is a host:port
+// or a URL.
+func NewKodi(host, user, password string, path_map PathMap) *Kodi {
+	url := strings.TrimSuffix(host, "/")
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	if !strings.HasSuffix(url, "/jsonrpc") {
+		url += "/jsonrpc"
+	}
+	return &Kodi{
+		url:      url,
+		user:     user,
+		password: password,
+		path_map: path_map,
+		client:   &http.Client{Timeout: kodi_timeout},
+	}
+}
+
+func (kodi *Kodi) PlayFile(file string) error {
+	return kodi.Call("Player.Open", map[string]interface{}{
+		"item": map[string]string{"file": kodi.path_map.ToRemote(file)},
+	}, nil)
+}
+
+func (kodi *Kodi) CanStartAt() bool {
+	return true
+}
+
+func (kodi *Kodi) PlayFileAt(file string, start int) error {
+	return kodi.Call("Player.Open", map[string]interface{}{
+		"item":    map[string]string{"file": kodi.path_map.ToRemote(file)},
+		"options": map[string]interface{}{"resume": seconds_to_kodi_time(start)},
+	}, nil)
+}
+
+// Enqueue adds file to the video playlist, or plays it if Kodi is idle.
+func (kodi *Kodi) Enqueue(file string) error {
+	if _, err := kodi.active_player(); err == ErrKodiIdle {
+		return kodi.PlayFile(file)
+	} else if err != nil {
+		return err
+	}
+	return kodi.Call("Playlist.Add", map[string]interface{}{
+		"playlistid": kodi_video_playlist,
+		"item":       map[string]string{"file": kodi.path_map.ToRemote(file)},
+	}, nil)
+}
+
+// PlayFiles replaces the video playlist with files and starts playing it.
+func (kodi *Kodi) PlayFiles(files []string) error {
+	items := make([]map[string]string, len(files))
+	for i, file := range files {
+		items[i] = map[string]string{"file": kodi.path_map.ToRemote(file)}
+	}
+	if err := kodi.Call("Playlist.Clear", map[string]interface{}{"playlistid": kodi_video_playlist}, nil); err != nil {
+		return err
+	}
+	err := kodi.Call("Playlist.Add", map[string]interface{}{"playlistid": kodi_video_playlist, "item": items}, nil)
+	if err != nil {
+		return err
+	}
+	return kodi.Call("Player.Open", map[string]interface{}{
+		"item": map[string]int{"playlistid": kodi_video_playlist, "position": 0},
+	}, nil)
+}
+
+func (kodi *Kodi) PlayerPlaylist() (files []string, err error) {
+	var result struct {
+		Items []struct {
+			File  string `json:"file"`
+			Label string `json:"label"`
+		} `json:"items"`
+	}
+	err = kodi.Call("Playlist.GetItems", map[string]interface{}{
+		"playlistid": kodi_video_playlist,
+		"properties": []string{"file"},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range result.Items {
+		if item.File != "" {
+			files = append(files, kodi.path_map.ToLocal(item.File))
+		} else {
+			files = append(files, item.Label)
+		}
+	}
+	return files, nil
+}
+
+func (kodi *Kodi) PlaybackStatus() (status PlaybackStatus, err error) {
+	player_id, err := kodi.active_player()
+	if err == ErrKodiIdle {
+		return PlaybackStatus{}, nil
+	} else if err != nil {
+		return PlaybackStatus{}, err
+	}
+
+	var item struct {
+		Item struct {
+			File string `json:"file"`
+		} `json:"item"`
+	}
+	err = kodi.Call("Player.GetItem", map[string]interface{}{
+		"playerid":   player_id,
+		"properties": []string{"file"},
+	}, &item)
+	if err != nil {
+		return PlaybackStatus{}, err
+	}
+
+	var properties struct {
+		Time      kodi_time `json:"time"`
+		TotalTime kodi_time `json:"totaltime"`
+		Speed     int       `json:"speed"`
+	}
+	err = kodi.Call("Player.GetProperties", map[string]interface{}{
+		"playerid":   player_id,
+		"properties": []string{"time", "totaltime", "speed"},
+	}, &properties)
+	if err != nil {
+		return PlaybackStatus{}, err
+	}
+
+	status.File = kodi.path_map.ToLocal(item.Item.File)
+	status.Position = properties.Time.seconds()
+	status.Length = properties.TotalTime.seconds()
+	status.Playing = properties.Speed != 0
+	return
+}
+
+func (kodi *Kodi) Capabilities() Capability {
+	return CapPause | CapStop | CapNext | CapPrevious | CapSeek | CapVolume | CapFullscreen
+}
+
+func (kodi *Kodi) Pause() error {
+	return kodi.call_player("Player.PlayPause", nil)
+}
+
+func (kodi *Kodi) Stop() error {
+	return kodi.call_player("Player.Stop", nil)
+}
+
+func (kodi *Kodi) Next() error {
+	return kodi.call_player("Player.GoTo", map[string]interface{}{"to": "next"})
+}
+
+func (kodi *Kodi) Previous() error {
+	return kodi.call_player("Player.GoTo", map[string]interface{}{"to": "previous"})
+}
+
+func (kodi *Kodi) Seek(seconds int) error {
+	return kodi.call_player("Player.Seek", map[string]interface{}{
+		"value": map[string]int{"seconds": seconds},
+	})
+}
+
+func (kodi *Kodi) ChangeVolume(percent int) error {
+	var properties struct {
+		Volume int `json:"volume"`
+	}
+	err := kodi.Call("Application.GetProperties", map[string]interface{}{
+		"properties": []string{"volume"},
+	}, &properties)
+	if err != nil {
+		return err
+	}
+	volume := properties.Volume + percent
+	if volume < 0 {
+		volume = 0
+	} else if volume > 100 {
+		volume = 100
+	}
+	return kodi.Call("Application.SetVolume", map[string]int{"volume": volume}, nil)
+}
+
+func (kodi *Kodi) ToggleFullscreen() error {
+	return kodi.Call("GUI.SetFullscreen", map[string]string{"fullscreen": "toggle"}, nil)
+}
+
+func (kodi *Kodi) active_player() (int, error) {
+	var players []struct {
+		PlayerID int    `json:"playerid"`
+		Type     string `json:"type"`
+	}
+	if err := kodi.Call("Player.GetActivePlayers", nil, &players); err != nil {
+		return 0, err
+	}
+	if len(players) == 0 {
+		return 0, ErrKodiIdle
+	}
+	for _, player := range players {
+		if player.Type == "video" {
+			return player.PlayerID, nil
+		}
+	}
+	return players[0].PlayerID, nil
+}
+
+// call_player calls method with the id of the active player added to params.
+func (kodi *Kodi) call_player(method string, params map[string]interface{}) error {
+	player_id, err := kodi.active_player()
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	params["playerid"] = player_id
+	return kodi.Call(method, params, nil)
+}
+
+// KodiError is an error reported by Kodi itself, as opposed to an error in
+// the communication with it.
+type KodiError struct {
+	Method  string
+	Code    int
+	Message string
+}
+
+func (err *KodiError) Error() string {
+	return fmt.Sprintf("Kodi: %s: %s (%d)", err.Method, err.Message, err.Code)
+}
+
+type kodi_request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	ID      int         `json:"id"`
+}
+
+type kodi_reply struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	ID int `json:"id"`
+}
+
+// Call calls the JSON-RPC method with params and unmarshals the result into
+// result, unless it is nil.
+func (kodi *Kodi) Call(method string, params interface{}, result interface{}) (err error) {
+	kodi.lock.Lock()
+	kodi.request_id++
+	id := kodi.request_id
+	kodi.lock.Unlock()
+
+	body, err := json.Marshal(kodi_request{"2.0", method, params, id})
+	if err != nil {
+		return err
+	}
+	// Methods like Player.GetProperties only ask
+	query := strings.HasPrefix(method[strings.Index(method, ".")+1:], "Get")
+	defer func() { log_command("kodi", string(body), query, nil, err) }()
+	request, err := http.NewRequest("POST", kodi.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if kodi.user != "" || kodi.password != "" {
+		request.SetBasicAuth(kodi.user, kodi.password)
+	}
+
+	response, err := kodi.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusUnauthorized {
+		return errors.New("Kodi: wrong user name or password")
+	} else if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kodi: %s: %s", method, response.Status)
+	}
+
+	var reply kodi_reply
+	if err = json.NewDecoder(response.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("Kodi: %s: %s", method, err)
+	}
+	if reply.Error != nil {
+		return &KodiError{method, reply.Error.Code, reply.Error.Message}
+	}
+	if result != nil {
+		return json.Unmarshal(reply.Result, result)
+	}
+	return nil
+}
+
+type kodi_time struct {
+	Hours        int `json:"hours"`
+	Minutes      int `json:"minutes"`
+	Seconds      int `json:"seconds"`
+	Milliseconds int `json:"milliseconds"`
+}
+
+func (t kodi_time) seconds() int {
+	return t.Hours*3600 + t.Minutes*60 + t.Seconds
+}
+
+func seconds_to_kodi_time(seconds int) kodi_time {
+	return kodi_time{Hours: seconds / 3600, Minutes: seconds / 60 % 60, Seconds: seconds % 60}
+}
+
+// PathMap translates between local paths and the paths a remote player sees
+// the same files as, like a share mounted locally at /mnt/media that Kodi
+// reads from smb://nas/media.
+type PathMap []PathMapping
+
+type PathMapping struct {
+	Local  string
+	Remote string
+}
+
+// ParsePathMap parses a comma separated list of local=remote prefixes.
+func ParsePathMap(spec string) (PathMap, error) {
+	var path_map PathMap
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%q is not of the form local=remote", pair)
+		}
+		path_map = append(path_map, PathMapping{strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])})
+	}
+	return path_map, nil
+}
+
+// ToRemote translates file with the mapping with the longest matching local
+// prefix. Files that no mapping matches are passed as they are.
+func (path_map PathMap) ToRemote(file string) string {
+	best := -1
+	for i, mapping := range path_map {
+		if has_path_prefix(file, mapping.Local) && (best < 0 || len(mapping.Local) > len(path_map[best].Local)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return file
+	}
+	mapping := path_map[best]
+	rest := filepath.ToSlash(strings.TrimLeft(file[len(mapping.Local):], "/\\"))
+	if rest == "" {
+		return mapping.Remote
+	}
+	if strings.Contains(mapping.Remote, "\\") && !strings.Contains(mapping.Remote, "/") {
+		// A windows path
+		return strings.TrimRight(mapping.Remote, "\\") + "\\" + strings.Replace(rest, "/", "\\", -1)
+	}
+	return strings.TrimRight(mapping.Remote, "/") + "/" + rest
+}
+
+// ToLocal is the inverse of ToRemote.
+func (path_map PathMap) ToLocal(file string) string {
+	best := -1
+	for i, mapping := range path_map {
+		if has_path_prefix(file, mapping.Remote) && (best < 0 || len(mapping.Remote) > len(path_map[best].Remote)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return file
+	}
+	mapping := path_map[best]
+	rest := strings.Replace(strings.TrimLeft(file[len(mapping.Remote):], "/\\"), "\\", "/", -1)
+	return filepath.Join(mapping.Local, filepath.FromSlash(rest))
+}
+
+// has_path_prefix reports whether prefix is file or one of its parents.
+func has_path_prefix(file, prefix string) bool {
+	if !strings.HasPrefix(file, prefix) {
+		return false
+	}
+	rest := file[len(prefix):]
+	return rest == "" || strings.HasSuffix(prefix, "/") || strings.HasSuffix(prefix, "\\") ||
+		rest[0] == '/' || rest[0] == '\\'
+}
@@ -0,0 +1,247 @@
+package media_player_test
+
+import (
+	"encoding/json"
+	"github.com/chrigrah/nextplz/media_player"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fake_kodi is a Kodi web server that records the JSON-RPC calls it gets and
+// answers them with the results it is given.
+type fake_kodi struct {
+	*httptest.Server
+
+	lock    sync.Mutex
+	calls   []string // Method and params, like Player.Open {"item":...}
+	results map[string]string
+	errors  map[string]string // Error objects
+}
+
+func new_fake_kodi() *fake_kodi {
+	kodi := &fake_kodi{
+		results: map[string]string{"Player.GetActivePlayers": `[]`},
+		errors:  make(map[string]string),
+	}
+	kodi.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "kodi" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			ID     int             `json:"id"`
+		}
+		if r.URL.Path != "/jsonrpc" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		kodi.lock.Lock()
+		defer kodi.lock.Unlock()
+		kodi.calls = append(kodi.calls, strings.TrimSpace(request.Method+" "+string(request.Params)))
+		if reply, ok := kodi.errors[request.Method]; ok {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":` + strconv.Itoa(request.ID) + `,"error":` + reply + `}`))
+			return
+		}
+		result, ok := kodi.results[request.Method]
+		if !ok {
+			result = `"OK"`
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":` + strconv.Itoa(request.ID) + `,"result":` + result + `}`))
+	}))
+	return kodi
+}
+
+func (kodi *fake_kodi) set_result(method, result string) {
+	kodi.lock.Lock()
+	defer kodi.lock.Unlock()
+	kodi.results[method] = result
+}
+
+func (kodi *fake_kodi) set_error(method, reply string) {
+	kodi.lock.Lock()
+	defer kodi.lock.Unlock()
+	kodi.errors[method] = reply
+}
+
+// take_calls returns and forgets the calls made so far.
+func (kodi *fake_kodi) take_calls() (calls []string) {
+	kodi.lock.Lock()
+	defer kodi.lock.Unlock()
+	calls, kodi.calls = kodi.calls, nil
+	return
+}
+
+func (kodi *fake_kodi) check_calls(t *testing.T, want ...string) {
+	t.Helper()
+	if calls := kodi.take_calls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("Calls = %q, want %q", calls, want)
+	}
+}
+
+var test_path_map = media_player.PathMap{
+	{Local: "/mnt/media", Remote: "smb://nas/media"},
+	{Local: "/mnt/media/tv", Remote: "nfs://nas/tv"},
+	{Local: "/home/me/videos", Remote: "D:\\Videos"},
+}
+
+func TestParsePathMap(t *testing.T) {
+	path_map, err := media_player.ParsePathMap(" /mnt/media=smb://nas/media, /mnt/media/tv=nfs://nas/tv,/home/me/videos=D:\\Videos,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(path_map, test_path_map) {
+		t.Errorf("ParsePathMap() = %q, want %q", path_map, test_path_map)
+	}
+	for _, spec := range []string{"/mnt/media", "=smb://nas/media", "/mnt/media="} {
+		if _, err := media_player.ParsePathMap(spec); err == nil {
+			t.Errorf("ParsePathMap(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestPathMap(t *testing.T) {
+	tests := []struct {
+		local, remote string
+	}{
+		{"/mnt/media/Movie.mkv", "smb://nas/media/Movie.mkv"},
+		{"/mnt/media", "smb://nas/media"},
+		{"/mnt/media/tv/Show/Show.S01E01.mkv", "nfs://nas/tv/Show/Show.S01E01.mkv"}, // The longest prefix
+		{"/home/me/videos/Movies/Movie.mkv", "D:\\Videos\\Movies\\Movie.mkv"},
+		{"/mnt/mediaplayer/Movie.mkv", "/mnt/mediaplayer/Movie.mkv"}, // Not a parent folder
+		{"/elsewhere/Movie.mkv", "/elsewhere/Movie.mkv"},
+	}
+	for _, test := range tests {
+		if remote := test_path_map.ToRemote(test.local); remote != test.remote {
+			t.Errorf("ToRemote(%q) = %q, want %q", test.local, remote, test.remote)
+		}
+		if local := test_path_map.ToLocal(test.remote); local != test.local {
+			t.Errorf("ToLocal(%q) = %q, want %q", test.remote, local, test.local)
+		}
+	}
+}
+
+func TestKodiPlays(t *testing.T) {
+	server := new_fake_kodi()
+	defer server.Close()
+	kodi := media_player.NewKodi(server.URL, "kodi", "secret", test_path_map)
+
+	if err := kodi.PlayFile("/mnt/media/Movie.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	server.check_calls(t, `Player.Open {"item":{"file":"smb://nas/media/Movie.mkv"}}`)
+
+	if err := kodi.PlayFileAt("/mnt/media/Movie.mkv", 3725); err != nil {
+		t.Fatal(err)
+	}
+	server.check_calls(t, `Player.Open {"item":{"file":"smb://nas/media/Movie.mkv"},`+
+		`"options":{"resume":{"hours":1,"minutes":2,"seconds":5,"milliseconds":0}}}`)
+
+	if err := kodi.PlayFiles([]string{"/mnt/media/tv/E01.mkv", "/mnt/media/tv/E02.mkv"}); err != nil {
+		t.Fatal(err)
+	}
+	server.check_calls(t,
+		`Playlist.Clear {"playlistid":1}`,
+		`Playlist.Add {"item":[{"file":"nfs://nas/tv/E01.mkv"},{"file":"nfs://nas/tv/E02.mkv"}],"playlistid":1}`,
+		`Player.Open {"item":{"playlistid":1,"position":0}}`)
+}
+
+func TestKodiEnqueue(t *testing.T) {
+	server := new_fake_kodi()
+	defer server.Close()
+	kodi := media_player.NewKodi(server.URL, "kodi", "secret", test_path_map)
+
+	// An idle Kodi plays the file
+	if err := kodi.Enqueue("/mnt/media/E01.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	server.check_calls(t, `Player.GetActivePlayers`, `Player.Open {"item":{"file":"smb://nas/media/E01.mkv"}}`)
+
+	server.set_result("Player.GetActivePlayers", `[{"playerid":1,"type":"video"}]`)
+	if err := kodi.Enqueue("/mnt/media/E02.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	server.check_calls(t, `Player.GetActivePlayers`, `Playlist.Add {"item":{"file":"smb://nas/media/E02.mkv"},"playlistid":1}`)
+
+	server.set_result("Playlist.GetItems", `{"items":[{"file":"smb://nas/media/E01.mkv","label":"E01"},{"label":"Stream"}]}`)
+	files, err := kodi.PlayerPlaylist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/mnt/media/E01.mkv", "Stream"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("PlayerPlaylist() = %q, want %q", files, want)
+	}
+}
+
+func TestKodiPlaybackStatus(t *testing.T) {
+	server := new_fake_kodi()
+	defer server.Close()
+	kodi := media_player.NewKodi(server.URL, "kodi", "secret", test_path_map)
+
+	status, err := kodi.PlaybackStatus()
+	if err != nil || status != (media_player.PlaybackStatus{}) {
+		t.Errorf("PlaybackStatus() of an idle Kodi = %+v, %v", status, err)
+	}
+
+	// The video player is preferred over the music player
+	server.set_result("Player.GetActivePlayers", `[{"playerid":0,"type":"audio"},{"playerid":1,"type":"video"}]`)
+	server.set_result("Player.GetItem", `{"item":{"file":"smb://nas/media/Movie.mkv","label":"Movie"}}`)
+	server.set_result("Player.GetProperties", `{"time":{"hours":0,"minutes":1,"seconds":30,"milliseconds":500},`+
+		`"totaltime":{"hours":1,"minutes":30,"seconds":0,"milliseconds":0},"speed":1}`)
+	server.take_calls()
+	status, err = kodi.PlaybackStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := media_player.PlaybackStatus{File: "/mnt/media/Movie.mkv", Position: 90, Length: 5400, Playing: true}
+	if status != want {
+		t.Errorf("PlaybackStatus() = %+v, want %+v", status, want)
+	}
+	server.check_calls(t, `Player.GetActivePlayers`,
+		`Player.GetItem {"playerid":1,"properties":["file"]}`,
+		`Player.GetProperties {"playerid":1,"properties":["time","totaltime","speed"]}`)
+
+	server.set_result("Player.GetProperties", `{"time":{},"totaltime":{},"speed":0}`)
+	if status, err = kodi.PlaybackStatus(); err != nil || status.Playing {
+		t.Errorf("PlaybackStatus() when paused = %+v, %v", status, err)
+	}
+}
+
+func TestKodiErrors(t *testing.T) {
+	server := new_fake_kodi()
+	defer server.Close()
+
+	kodi := media_player.NewKodi(server.URL, "kodi", "secret", nil)
+	server.set_error("Player.Open", `{"code":-32602,"message":"Invalid params."}`)
+	err := kodi.PlayFile("/videos/Movie.mkv")
+	if kodi_err, ok := err.(*media_player.KodiError); !ok || kodi_err.Method != "Player.Open" || kodi_err.Code != -32602 || kodi_err.Message != "Invalid params." {
+		t.Errorf("PlayFile() refused by Kodi returned %#v", err)
+	}
+
+	// Controls need a player
+	if err = kodi.Pause(); err != media_player.ErrKodiIdle {
+		t.Errorf("Pause() of an idle Kodi returned %v, want %v", err, media_player.ErrKodiIdle)
+	}
+
+	wrong_password := media_player.NewKodi(server.URL, "kodi", "wrong", nil)
+	if err = wrong_password.PlayFile("/videos/Movie.mkv"); err == nil || !strings.Contains(err.Error(), "password") {
+		t.Errorf("PlayFile() with the wrong password returned %v", err)
+	}
+
+	wrong_path := media_player.NewKodi(server.URL+"/elsewhere", "kodi", "secret", nil)
+	if err = wrong_path.PlayFile("/videos/Movie.mkv"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("PlayFile() with the wrong URL returned %v", err)
+	}
+
+	server.Close()
+	if err = kodi.PlayFile("/videos/Movie.mkv"); err == nil {
+		t.Errorf("PlayFile() with Kodi gone succeeded")
+	}
+}
@@ -0,0 +1,159 @@
+package media_player
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	player_log_size    = 1000 // Lines of traffic with players
+	process_log_size   = 500  // Lines of output per process
+	process_log_source = "nextplz"
+)
+
+// PlayerLog records the commands sent to players and how they went, and the
+// command lines players are started with. Output of the players is kept with
+// their processes, see Supervisor.LogEntries.
+var PlayerLog = NewLogRing(process_log_source, player_log_size)
+
+var (
+	query_errors_lock sync.Mutex
+	query_errors      = make(map[string]string) // Last error of each failing query
+)
+
+// LogEntry is a line of player output, or of traffic with a player.
+type LogEntry struct {
+	Time   time.Time
+	Source string // Like vlc[1234] for output, or vlc rc for traffic
+	Text   string
+}
+
+// LogRing keeps the last lines added or written to it. As an io.Writer it
+// captures the output of a process. Lines rewritten with carriage returns,
+// like status lines, are only kept as they were last written.
+type LogRing struct {
+	lock    sync.Mutex
+	source  string
+	entries []LogEntry
+	start   int // Index of the oldest entry once the ring is full
+	size    int
+	partial []byte
+	cr      bool
+}
+
+func NewLogRing(source string, size int) *LogRing {
+	return &LogRing{source: source, size: size}
+}
+
+// SetSource changes the source of lines written from now on.
+func (lr *LogRing) SetSource(source string) {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	lr.source = source
+}
+
+// Add adds a line from source.
+func (lr *LogRing) Add(source, text string) {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	lr.add(source, text)
+}
+
+func (lr *LogRing) Addf(source, format string, args ...interface{}) {
+	lr.Add(source, fmt.Sprintf(format, args...))
+}
+
+func (lr *LogRing) Write(p []byte) (int, error) {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+
+	for _, b := range p {
+		switch {
+		case b == '\n':
+			lr.add(lr.source, string(lr.partial))
+			lr.partial = lr.partial[:0]
+		case lr.cr:
+			lr.partial = append(lr.partial[:0], b) // The line is being rewritten
+		case b != '\r':
+			lr.partial = append(lr.partial, b)
+		}
+		lr.cr = b == '\r'
+	}
+	return len(p), nil
+}
+
+// Entries returns the lines in the ring, oldest first.
+func (lr *LogRing) Entries() []LogEntry {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	return append(append([]LogEntry(nil), lr.entries[lr.start:]...), lr.entries[:lr.start]...)
+}
+
+func (lr *LogRing) add(source, text string) {
+	entry := LogEntry{time.Now(), source, strings.TrimRight(text, " \t")}
+	if len(lr.entries) < lr.size {
+		lr.entries = append(lr.entries, entry)
+		return
+	}
+	lr.entries[lr.start] = entry
+	lr.start = (lr.start + 1) % lr.size
+}
+
+// log_command records a command sent to a player, its reply and error.
+// Queries are only recorded when they fail, and a failure that repeats is
+// recorded once, as players are polled for their status every second.
+func log_command(source, command string, query bool, reply []string, err error) {
+	if query {
+		key := source + " " + command
+		query_errors_lock.Lock()
+		repeated := err != nil && query_errors[key] == err.Error()
+		if err != nil {
+			query_errors[key] = err.Error()
+		} else {
+			delete(query_errors, key)
+		}
+		query_errors_lock.Unlock()
+		if err == nil || repeated {
+			return
+		}
+	}
+
+	PlayerLog.Add(source, "> "+command)
+	for _, line := range reply {
+		PlayerLog.Add(source, "< "+line)
+	}
+	if err != nil {
+		PlayerLog.Add(source, "! "+err.Error())
+	}
+}
+
+// LogEntries returns the PlayerLog together with the output of the processes
+// started lately, in the order it happened.
+func (s *Supervisor) LogEntries() []LogEntry {
+	entries := PlayerLog.Entries()
+	s.lock.Lock()
+	for _, p := range s.recent {
+		entries = append(entries, p.output.Entries()...)
+	}
+	s.lock.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries
+}
+
+// command_line writes args the way they would be typed in a shell, single
+// quoting the ones that need it.
+func command_line(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`*?[]{}()<>|&;#~") {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,346 @@
+package media_player
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mplayer_answer_timeout = 2 * time.Second
+	mplayer_open_timeout   = 10 * time.Second
+
+	// Longer lines of output are split, so that reading answers never stops
+	// at a line that doesn't fit the buffer of the scanner
+	mplayer_max_line = 4096
+)
+
+var ErrNoSessionMPlayer = errors.New("No MPlayer has been started by this nextplz session")
+
+// MPlayer controls an MPlayer or mplayer2 started by this session in slave
+// mode. Commands are written to its stdin, and answers to property queries
+// are read from its stdout.
+type MPlayer struct {
+	executable string
+
+	lock    sync.Mutex // Held for a command and its answer
+	stdin   io.WriteCloser
+	answers chan string
+	owned   *Process
+	running bool
+}
+
+func CreateMPlayer() (MediaPlayer, error) {
+	executable, err := exec.LookPath("mplayer")
+	if err != nil {
+		var err2 error
+		if executable, err2 = exec.LookPath("mplayer2"); err2 != nil {
+			return nil, err
+		}
+	}
+	return MediaPlayer(&MPlayer{executable: executable}), nil
+}
+
+func (mp *MPlayer) PlayFile(file string) error {
+	if !mp.is_running() {
+		return mp.start(file)
+	}
+	return mp.Command("loadfile " + mplayer_quote(file))
+}
+
+// Enqueue appends file to the playlist. An idle MPlayer starts playing it.
+func (mp *MPlayer) Enqueue(file string) error {
+	if status, err := mp.PlaybackStatus(); err != nil || status.File == "" {
+		return mp.PlayFile(file)
+	}
+	return mp.Command("loadfile " + mplayer_quote(file) + " 1")
+}
+
+// PlayFiles plays the first of files and queues the rest after it.
+func (mp *MPlayer) PlayFiles(files []string) error {
+	if !mp.is_running() {
+		return mp.start(files...)
+	}
+	if err := mp.Command("loadfile " + mplayer_quote(files[0])); err != nil {
+		return err
+	}
+	for _, file := range files[1:] {
+		if err := mp.Command("loadfile " + mplayer_quote(file) + " 1"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mp *MPlayer) CanStartAt() bool {
+	return true
+}
+
+// PlayFileAt seeks once MPlayer has opened file, as MPlayer has no per file
+// start option and a seek is carried out on whatever is loaded when it
+// arrives.
+func (mp *MPlayer) PlayFileAt(file string, start int) error {
+	if err := mp.PlayFile(file); err != nil {
+		return err
+	}
+	if err := mp.wait_for_file(file); err != nil {
+		return err
+	}
+	return mp.Command(fmt.Sprintf("seek %d 2", start))
+}
+
+// wait_for_file waits until MPlayer plays file, which is when it has a
+// position in it.
+func (mp *MPlayer) wait_for_file(file string) error {
+	deadline := time.Now().Add(mplayer_open_timeout)
+	for {
+		path, err := mp.GetProperty("path")
+		if err == nil && path == file {
+			if _, err = mp.GetProperty("time_pos"); err == nil {
+				return nil
+			}
+		}
+		if _, unavailable := err.(*MPlayerError); err != nil && !unavailable {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("MPlayer did not open %s in time to resume it", file)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (mp *MPlayer) PlaybackStatus() (status PlaybackStatus, err error) {
+	if !mp.is_running() {
+		return status, nil
+	}
+
+	status.File, err = mp.GetProperty("path")
+	if _, unavailable := err.(*MPlayerError); unavailable {
+		return PlaybackStatus{}, nil // Idle
+	} else if err != nil {
+		return PlaybackStatus{}, err
+	}
+
+	position, err := mp.GetProperty("time_pos")
+	if err != nil {
+		return PlaybackStatus{}, err
+	}
+	length, _ := mp.GetProperty("length") // Unavailable for some streams
+	paused, err := mp.GetProperty("pause")
+	if err != nil {
+		return PlaybackStatus{}, err
+	}
+
+	position_f, _ := strconv.ParseFloat(position, 64)
+	length_f, _ := strconv.ParseFloat(length, 64)
+	status.Position = int(position_f)
+	status.Length = int(length_f)
+	status.Playing = paused != "yes"
+	return
+}
+
+func (mp *MPlayer) Capabilities() Capability {
+	return CapPause | CapStop | CapNext | CapPrevious | CapSeek | CapVolume | CapFullscreen
+}
+
+func (mp *MPlayer) Pause() error {
+	return mp.Command("pause")
+}
+
+func (mp *MPlayer) Stop() error {
+	return mp.Command("stop")
+}
+
+func (mp *MPlayer) Next() error {
+	return mp.Command("pt_step 1")
+}
+
+func (mp *MPlayer) Previous() error {
+	return mp.Command("pt_step -1")
+}
+
+// Most commands unpause MPlayer, pausing_keep keeps it as it was.
+
+func (mp *MPlayer) Seek(seconds int) error {
+	return mp.Command(fmt.Sprintf("pausing_keep seek %+d 0", seconds))
+}
+
+func (mp *MPlayer) ChangeVolume(percent int) error {
+	return mp.Command(fmt.Sprintf("pausing_keep step_property volume %d", percent))
+}
+
+func (mp *MPlayer) ToggleFullscreen() error {
+	return mp.Command("pausing_keep vo_fullscreen")
+}
+
+// MPlayerError is an ANS_ERROR answer, which MPlayer gives for properties
+// that are unavailable, like the path when nothing is playing.
+type MPlayerError struct {
+	Property string
+	Message  string
+}
+
+func (err *MPlayerError) Error() string {
+	return fmt.Sprintf("MPlayer: %s: %s", err.Property, err.Message)
+}
+
+// GetProperty asks MPlayer for the value of a property.
+func (mp *MPlayer) GetProperty(name string) (value string, err error) {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	defer func() { log_command("mplayer", "get_property "+name, true, nil, err) }()
+
+	if !mp.running {
+		return "", ErrNoSessionMPlayer
+	}
+	for len(mp.answers) > 0 {
+		<-mp.answers // Late answers to queries that timed out
+	}
+	if err := mp.write("pausing_keep_force get_property " + name); err != nil {
+		return "", err
+	}
+
+	timeout := time.After(mplayer_answer_timeout)
+	for {
+		select {
+		case answer, ok := <-mp.answers:
+			if !ok {
+				return "", ErrNoSessionMPlayer
+			}
+			if value := strings.TrimPrefix(answer, "ANS_ERROR="); value != answer {
+				return "", &MPlayerError{name, value}
+			}
+			if value := strings.TrimPrefix(answer, "ANS_"+name+"="); value != answer {
+				return strings.Trim(value, "'"), nil
+			}
+		case <-timeout:
+			return "", fmt.Errorf("MPlayer did not answer for %s", name)
+		}
+	}
+}
+
+// Command sends a slave mode command to the MPlayer started by this session.
+func (mp *MPlayer) Command(command string) (err error) {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	defer func() { log_command("mplayer", command, false, nil, err) }()
+
+	if !mp.running {
+		return ErrNoSessionMPlayer
+	}
+	return mp.write(command)
+}
+
+func (mp *MPlayer) write(command string) error {
+	if strings.ContainsAny(command, "\r\n") {
+		return fmt.Errorf("MPlayer commands can't contain newlines: %q", command)
+	}
+	_, err := io.WriteString(mp.stdin, command+"\n")
+	return err
+}
+
+func (mp *MPlayer) is_running() bool {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	return mp.running
+}
+
+// start starts an MPlayer owned by this session, playing files. -idle keeps
+// it running when the playlist ends, so that more files can be loaded.
+func (mp *MPlayer) start(files ...string) error {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	args := append([]string{"-slave", "-idle", "-quiet", "--"}, files...)
+	command := exec.Command(mp.executable, args...)
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout_reader, stdout_writer := io.Pipe()
+	command.Stdout = stdout_writer
+
+	process, err := GlobalSupervisor.Start(command)
+	if err != nil {
+		stdin.Close()
+		return err
+	}
+
+	answers := make(chan string, 16)
+	mp.stdin = stdin
+	mp.answers = answers
+	mp.owned = process
+	mp.running = true
+
+	go func() {
+		scanner := bufio.NewScanner(stdout_reader)
+		scanner.Split(scan_terminal_lines)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "ANS_") {
+				if line != "" {
+					process.Output().Add(process.String(), line)
+				}
+				continue
+			}
+			select {
+			case answers <- line:
+			default: // Nobody is asking
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			// Without answers this MPlayer can't be controlled, so the next
+			// file starts another one
+			process.Output().Add(process.String(), "Reading the output of MPlayer failed: "+err.Error())
+			mp.lock.Lock()
+			if mp.owned == process {
+				mp.running = false
+			}
+			mp.lock.Unlock()
+		}
+		close(answers)
+		io.Copy(ioutil.Discard, stdout_reader) // Never leave MPlayer blocked on a write
+	}()
+
+	go func() {
+		<-process.Done()
+		stdout_writer.Close()
+		mp.lock.Lock()
+		if mp.owned == process {
+			mp.running = false
+		}
+		mp.lock.Unlock()
+	}()
+
+	return nil
+}
+
+// scan_terminal_lines is bufio.ScanLines, except that carriage returns also
+// end lines, as MPlayer rewrites its status line with them, and that lines
+// longer than mplayer_max_line are split.
+func scan_terminal_lines(data []byte, at_eof bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 && i < mplayer_max_line {
+		return i + 1, data[:i], nil
+	}
+	if len(data) >= mplayer_max_line {
+		return mplayer_max_line, data[:mplayer_max_line], nil
+	}
+	if at_eof && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// mplayer_quote quotes file as a string argument of a slave mode command.
+func mplayer_quote(file string) string {
+	return `"` + strings.Replace(strings.Replace(file, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
@@ -0,0 +1,79 @@
+package media_player_test
+
+import (
+	"github.com/chrigrah/nextplz/media_player"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fake_mplayer is a shell script that answers the properties nextplz asks
+// for, with the file it was started with as the path. It writes the commands
+// it gets to a file, one per line, and starts with a line longer than any
+// scanner buffer.
+const fake_mplayer = `#!/bin/sh
+head -c 100000 /dev/zero | tr '\0' x
+echo
+while read -r line; do
+	echo "$line" >> "$(dirname "$0")/commands"
+	case "$line" in
+	*"get_property path") echo "ANS_path=$5";;
+	*"get_property time_pos") echo "ANS_time_pos=0.5";;
+	*"get_property length") echo "ANS_length=5400.0";;
+	*"get_property pause") echo "ANS_pause=no";;
+	quit) exit;;
+	esac
+done
+`
+
+func TestMPlayerResumes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The fake MPlayer is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "nextplz-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "mplayer"), []byte(fake_mplayer), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	mp, err := media_player.CreateMPlayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mplayer := mp.(*media_player.MPlayer)
+	defer mplayer.Command("quit")
+	if err = mplayer.PlayFileAt("/videos/Movie.mkv", 90); err != nil {
+		t.Fatal(err)
+	}
+
+	// The seek waits until the file is open
+	var commands []string
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "commands"))
+		if commands = strings.Split(strings.TrimSpace(string(data)), "\n"); strings.HasPrefix(commands[len(commands)-1], "seek") {
+			break
+		}
+	}
+	want := []string{"pausing_keep_force get_property path", "pausing_keep_force get_property time_pos", "seek 90 2"}
+	if len(commands) < 3 || strings.Join(commands[len(commands)-3:], "\n") != strings.Join(want, "\n") {
+		t.Errorf("Commands = %q, want them to end with %q", commands, want)
+	}
+
+	// The long line didn't stop the answers
+	status, err := mplayer.PlaybackStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (media_player.PlaybackStatus{File: "/videos/Movie.mkv", Length: 5400, Playing: true}); status != want {
+		t.Errorf("PlaybackStatus() = %+v, want %+v", status, want)
+	}
+}
@@ -0,0 +1,339 @@
+package media_player
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	mpris_prefix          = "org.mpris.MediaPlayer2."
+	mpris_path            = "/org/mpris/MediaPlayer2"
+	mpris_root_iface      = "org.mpris.MediaPlayer2"
+	mpris_player_iface    = "org.mpris.MediaPlayer2.Player"
+	mpris_tracklist_iface = "org.mpris.MediaPlayer2.TrackList"
+	mpris_no_track        = "/org/mpris/MediaPlayer2/TrackList/NoTrack"
+)
+
+var ErrNoMPRISPlayer = errors.New("No MPRIS player is running")
+
+// MPRISInfo selects the MPRIS player to control. An empty Player picks the
+// first player found, and an empty Bus means the session bus.
+type MPRISInfo struct {
+	Player string
+	Bus    string
+}
+
+// MPRIS controls any running player that implements the MPRIS D-Bus
+// interface, like VLC, Celluloid or mpv with the mpris plugin. It can't start
+// a player, only hand files to one that is running.
+type MPRIS struct {
+	player      string
+	bus_address string
+
+	lock sync.Mutex
+	conn *dbus.Conn
+}
+
+func CreateMPRIS(info MPRISInfo) (MediaPlayer, error) {
+	return MediaPlayer(&MPRIS{player: info.Player, bus_address: info.Bus}), nil
+}
+
+func (mpris *MPRIS) PlayFile(file string) error {
+	return mpris.call(mpris_player_iface+".OpenUri", path_to_mrl(file))
+}
+
+// Enqueue adds file to the end of the track list of the player, if it has
+// one.
+func (mpris *MPRIS) Enqueue(file string) error {
+	var has_tracklist bool
+	if err := mpris.get_property(mpris_root_iface, "HasTrackList", &has_tracklist); err != nil {
+		return err
+	}
+	if !has_tracklist {
+		return errors.New("The MPRIS player has no track list to queue files to")
+	}
+
+	after := dbus.ObjectPath(mpris_no_track)
+	var tracks []dbus.ObjectPath
+	if err := mpris.get_property(mpris_tracklist_iface, "Tracks", &tracks); err == nil && len(tracks) > 0 {
+		after = tracks[len(tracks)-1]
+	}
+	return mpris.call(mpris_tracklist_iface+".AddTrack", path_to_mrl(file), after, false)
+}
+
+// PlayerPlaylist returns the paths of the entries in the track list of the
+// player, which is empty for players without one.
+func (mpris *MPRIS) PlayerPlaylist() (files []string, err error) {
+	var has_tracklist bool
+	if err = mpris.get_property(mpris_root_iface, "HasTrackList", &has_tracklist); err != nil || !has_tracklist {
+		return nil, err
+	}
+	var tracks []dbus.ObjectPath
+	if err = mpris.get_property(mpris_tracklist_iface, "Tracks", &tracks); err != nil || len(tracks) == 0 {
+		return nil, err
+	}
+
+	obj, err := mpris.object()
+	if err != nil {
+		return nil, err
+	}
+	var metadata []map[string]dbus.Variant
+	err = mpris.store(obj.Call(mpris_tracklist_iface+".GetTracksMetadata", 0, tracks), &metadata)
+	if err != nil {
+		return nil, err
+	}
+	for _, track := range metadata {
+		if url, ok := track["xesam:url"].Value().(string); ok {
+			files = append(files, mrl_to_path(url))
+		}
+	}
+	return files, nil
+}
+
+func (mpris *MPRIS) PlaybackStatus() (status PlaybackStatus, err error) {
+	var state string
+	if err = mpris.get_property(mpris_player_iface, "PlaybackStatus", &state); err == ErrNoMPRISPlayer {
+		return PlaybackStatus{}, nil
+	} else if err != nil || state == "Stopped" {
+		return PlaybackStatus{}, err
+	}
+
+	var metadata map[string]dbus.Variant
+	if err = mpris.get_property(mpris_player_iface, "Metadata", &metadata); err != nil {
+		return PlaybackStatus{}, err
+	}
+	url, _ := metadata["xesam:url"].Value().(string)
+	if url == "" {
+		return PlaybackStatus{}, nil
+	}
+	var position int64
+	if err = mpris.get_property(mpris_player_iface, "Position", &position); err != nil {
+		return PlaybackStatus{}, err
+	}
+
+	status.File = mrl_to_path(url)
+	status.Position = int(position / 1000000)
+	status.Length = int(variant_int64(metadata["mpris:length"]) / 1000000)
+	status.Playing = state == "Playing"
+	return
+}
+
+// variant_int64 reads an integer that players send as any integer type.
+func variant_int64(v dbus.Variant) int64 {
+	switch value := v.Value().(type) {
+	case int64:
+		return value
+	case uint64:
+		return int64(value)
+	case int32:
+		return int64(value)
+	case uint32:
+		return int64(value)
+	}
+	return 0
+}
+
+// Capabilities asks the player what it can do. If it can't be asked, like
+// when no player is running, everything is reported as supported so that the
+// action fails with the reason instead.
+func (mpris *MPRIS) Capabilities() Capability {
+	all := CapPause | CapStop | CapNext | CapPrevious | CapSeek | CapVolume | CapFullscreen
+	var player map[string]dbus.Variant
+	if err := mpris.get_all_properties(mpris_player_iface, &player); err != nil {
+		return all
+	}
+	can := func(name string) bool {
+		value, _ := player[name].Value().(bool)
+		return value
+	}
+
+	var caps Capability
+	if can("CanControl") {
+		// Stopping and the volume are only tied to CanControl
+		caps |= CapStop | CapVolume
+		for _, property := range []struct {
+			name string
+			cap  Capability
+		}{
+			{"CanPause", CapPause},
+			{"CanSeek", CapSeek},
+			{"CanGoNext", CapNext},
+			{"CanGoPrevious", CapPrevious},
+		} {
+			if can(property.name) {
+				caps |= property.cap
+			}
+		}
+	}
+	var can_set_fullscreen bool
+	mpris.get_property(mpris_root_iface, "CanSetFullscreen", &can_set_fullscreen)
+	if can_set_fullscreen {
+		caps |= CapFullscreen
+	}
+	return caps
+}
+
+func (mpris *MPRIS) Pause() error {
+	return mpris.call(mpris_player_iface + ".PlayPause")
+}
+
+func (mpris *MPRIS) Stop() error {
+	return mpris.call(mpris_player_iface + ".Stop")
+}
+
+func (mpris *MPRIS) Next() error {
+	return mpris.call(mpris_player_iface + ".Next")
+}
+
+func (mpris *MPRIS) Previous() error {
+	return mpris.call(mpris_player_iface + ".Previous")
+}
+
+func (mpris *MPRIS) Seek(seconds int) error {
+	return mpris.call(mpris_player_iface+".Seek", int64(seconds)*1000000)
+}
+
+func (mpris *MPRIS) ChangeVolume(percent int) error {
+	var volume float64
+	if err := mpris.get_property(mpris_player_iface, "Volume", &volume); err != nil {
+		return err
+	}
+	volume += float64(percent) / 100
+	if volume < 0 {
+		volume = 0
+	}
+	return mpris.set_property(mpris_player_iface, "Volume", volume)
+}
+
+func (mpris *MPRIS) ToggleFullscreen() error {
+	var can_set bool
+	mpris.get_property(mpris_root_iface, "CanSetFullscreen", &can_set)
+	if !can_set {
+		return &NotSupportedError{CapFullscreen}
+	}
+	var fullscreen bool
+	if err := mpris.get_property(mpris_root_iface, "Fullscreen", &fullscreen); err != nil {
+		return err
+	}
+	return mpris.set_property(mpris_root_iface, "Fullscreen", !fullscreen)
+}
+
+func (mpris *MPRIS) call(method string, args ...interface{}) (err error) {
+	defer func() { log_command("mpris", fmt.Sprint(method, args), false, nil, err) }()
+	obj, err := mpris.object()
+	if err != nil {
+		return err
+	}
+	return mpris.store(obj.Call(method, 0, args...))
+}
+
+func (mpris *MPRIS) get_property(iface, name string, value interface{}) (err error) {
+	defer func() { log_command("mpris", "Get "+iface+"."+name, true, nil, err) }()
+	obj, err := mpris.object()
+	if err != nil {
+		return err
+	}
+	var variant dbus.Variant
+	if err = mpris.store(obj.Call("org.freedesktop.DBus.Properties.Get", 0, iface, name), &variant); err != nil {
+		return err
+	}
+	return dbus.Store([]interface{}{variant.Value()}, value)
+}
+
+func (mpris *MPRIS) get_all_properties(iface string, values *map[string]dbus.Variant) (err error) {
+	defer func() { log_command("mpris", "GetAll "+iface, true, nil, err) }()
+	obj, err := mpris.object()
+	if err != nil {
+		return err
+	}
+	return mpris.store(obj.Call("org.freedesktop.DBus.Properties.GetAll", 0, iface), values)
+}
+
+func (mpris *MPRIS) set_property(iface, name string, value interface{}) (err error) {
+	defer func() { log_command("mpris", fmt.Sprintf("Set %s.%s %v", iface, name, value), false, nil, err) }()
+	obj, err := mpris.object()
+	if err != nil {
+		return err
+	}
+	return mpris.store(obj.Call("org.freedesktop.DBus.Properties.Set", 0, iface, name, dbus.MakeVariant(value)))
+}
+
+// store stores the reply of call in values. A connection that broke is
+// dropped, so that the next call reconnects.
+func (mpris *MPRIS) store(call *dbus.Call, values ...interface{}) error {
+	err := call.Store(values...)
+	if _, is_dbus_err := err.(dbus.Error); err != nil && !is_dbus_err {
+		mpris.lock.Lock()
+		if mpris.conn != nil {
+			mpris.conn.Close()
+		}
+		mpris.conn = nil
+		mpris.lock.Unlock()
+	}
+	return err
+}
+
+// object returns the MPRIS object of the player, looking for the player
+// every time as players come and go.
+func (mpris *MPRIS) object() (dbus.BusObject, error) {
+	conn, err := mpris.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if err = mpris.store(conn.BusObject().Call("org.freedesktop.DBus.ListNames", 0), &names); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	wanted := mpris_prefix + mpris.player
+	for _, name := range names {
+		if !strings.HasPrefix(name, mpris_prefix) {
+			continue
+		}
+		// Players with several instances append .instance<pid> to their name
+		if mpris.player == "" || name == wanted || strings.HasPrefix(name, wanted+".") {
+			return conn.Object(name, mpris_path), nil
+		}
+	}
+	if mpris.player != "" {
+		return nil, fmt.Errorf("The MPRIS player %s is not running", mpris.player)
+	}
+	return nil, ErrNoMPRISPlayer
+}
+
+func (mpris *MPRIS) connect() (*dbus.Conn, error) {
+	mpris.lock.Lock()
+	defer mpris.lock.Unlock()
+
+	if mpris.conn != nil {
+		return mpris.conn, nil
+	}
+
+	var conn *dbus.Conn
+	var err error
+	if mpris.bus_address == "" {
+		// A connection of our own, as the shared one of dbus.SessionBus stays
+		// broken once it breaks
+		conn, err = dbus.SessionBusPrivate()
+	} else {
+		conn, err = dbus.Dial(mpris.bus_address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	mpris.conn = conn
+	return conn, nil
+}
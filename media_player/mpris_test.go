@@ -0,0 +1,273 @@
+package media_player_test
+
+import (
+	"bufio"
+	"github.com/chrigrah/nextplz/media_player"
+	"github.com/godbus/dbus"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// start_dbus starts a bus of its own for a test, or skips the test if there
+// is no dbus-daemon. The returned function stops it.
+func start_dbus(t *testing.T) (address string, stop func()) {
+	executable, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	cmd := exec.Command(executable, "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Skipf("dbus-daemon could not be started: %s", err)
+	}
+	stop = func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	address, err = bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		stop()
+		t.Skipf("dbus-daemon didn't say where it listens: %s", err)
+	}
+	return strings.TrimSpace(address), stop
+}
+
+// fake_mpris is a player that exports as much of the MPRIS interface as
+// nextplz uses, and records the calls made to it.
+type fake_mpris struct {
+	lock       sync.Mutex
+	calls      []string
+	properties map[string]interface{} // By interface.name
+	urls       map[dbus.ObjectPath]string
+}
+
+func export_fake_mpris(t *testing.T, address, name string) (*fake_mpris, *dbus.Conn) {
+	conn, err := dbus.Dial(address)
+	if err == nil {
+		err = conn.Auth(nil)
+	}
+	if err == nil {
+		err = conn.Hello()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	player := &fake_mpris{
+		properties: map[string]interface{}{
+			"org.mpris.MediaPlayer2.HasTrackList":          true,
+			"org.mpris.MediaPlayer2.CanSetFullscreen":      true,
+			"org.mpris.MediaPlayer2.Player.CanControl":     true,
+			"org.mpris.MediaPlayer2.Player.CanPause":       true,
+			"org.mpris.MediaPlayer2.Player.CanSeek":        true,
+			"org.mpris.MediaPlayer2.Player.CanGoNext":      true,
+			"org.mpris.MediaPlayer2.Player.CanGoPrevious":  true,
+			"org.mpris.MediaPlayer2.Player.PlaybackStatus": "Stopped",
+			"org.mpris.MediaPlayer2.Player.Metadata":       map[string]dbus.Variant{},
+			"org.mpris.MediaPlayer2.Player.Position":       int64(0),
+			"org.mpris.MediaPlayer2.TrackList.Tracks":      []dbus.ObjectPath{},
+		},
+		urls: make(map[dbus.ObjectPath]string),
+	}
+	path := dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	for _, iface := range []string{"org.freedesktop.DBus.Properties", "org.mpris.MediaPlayer2.Player", "org.mpris.MediaPlayer2.TrackList"} {
+		if err = conn.Export(player, path, iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply, err := conn.RequestName("org.mpris.MediaPlayer2."+name, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("Could not take the name of the player: %v, %v", reply, err)
+	}
+	return player, conn
+}
+
+func (player *fake_mpris) set(property string, value interface{}) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	player.properties[property] = value
+}
+
+func (player *fake_mpris) set_url(track dbus.ObjectPath, url string) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	player.urls[track] = url
+}
+
+// take_calls returns and forgets the calls made so far.
+func (player *fake_mpris) take_calls() (calls []string) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	calls, player.calls = player.calls, nil
+	return
+}
+
+func (player *fake_mpris) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	value, ok := player.properties[iface+"."+name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"No such property " + name})
+	}
+	return dbus.MakeVariant(value), nil
+}
+
+func (player *fake_mpris) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	properties := make(map[string]dbus.Variant)
+	for name, value := range player.properties {
+		if strings.HasPrefix(name, iface+".") && !strings.Contains(name[len(iface)+1:], ".") {
+			properties[name[len(iface)+1:]] = dbus.MakeVariant(value)
+		}
+	}
+	return properties, nil
+}
+
+func (player *fake_mpris) OpenUri(uri string) *dbus.Error {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	player.calls = append(player.calls, "OpenUri "+uri)
+	return nil
+}
+
+func (player *fake_mpris) AddTrack(uri string, after dbus.ObjectPath, set_as_current bool) *dbus.Error {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	player.calls = append(player.calls, "AddTrack "+uri+" "+string(after))
+	return nil
+}
+
+func (player *fake_mpris) GetTracksMetadata(tracks []dbus.ObjectPath) ([]map[string]dbus.Variant, *dbus.Error) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	var metadata []map[string]dbus.Variant
+	for _, track := range tracks {
+		metadata = append(metadata, map[string]dbus.Variant{
+			"mpris:trackid": dbus.MakeVariant(track),
+			"xesam:url":     dbus.MakeVariant(player.urls[track]),
+		})
+	}
+	return metadata, nil
+}
+
+func TestMPRISWithoutPlayer(t *testing.T) {
+	address, stop := start_dbus(t)
+	defer stop()
+
+	mpris, _ := media_player.CreateMPRIS(media_player.MPRISInfo{Bus: address})
+	status, err := mpris.(media_player.StatusReporter).PlaybackStatus()
+	if err != nil || status != (media_player.PlaybackStatus{}) {
+		t.Errorf("PlaybackStatus() without a player = %+v, %v", status, err)
+	}
+	if err = mpris.PlayFile("/videos/Movie.mkv"); err != media_player.ErrNoMPRISPlayer {
+		t.Errorf("PlayFile() without a player returned %v, want %v", err, media_player.ErrNoMPRISPlayer)
+	}
+	// The controls say why they don't work, rather than that they aren't supported
+	if _, err = media_player.GetControl(mpris, media_player.CapPause); err != nil {
+		t.Errorf("GetControl() without a player returned %v", err)
+	}
+}
+
+func TestMPRIS(t *testing.T) {
+	address, stop := start_dbus(t)
+	defer stop()
+	player, conn := export_fake_mpris(t, address, "fake.instance1234")
+	defer conn.Close()
+
+	other, _ := media_player.CreateMPRIS(media_player.MPRISInfo{Player: "other", Bus: address})
+	if err := other.PlayFile("/videos/Movie.mkv"); err == nil || !strings.Contains(err.Error(), "other") {
+		t.Errorf("PlayFile() with another player named returned %v", err)
+	}
+
+	mp, _ := media_player.CreateMPRIS(media_player.MPRISInfo{Player: "fake", Bus: address})
+	mpris := mp.(*media_player.MPRIS)
+
+	if err := mpris.PlayFile("/videos/A Movie.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := player.take_calls(); !reflect.DeepEqual(calls, []string{"OpenUri file:///videos/A%20Movie.mkv"}) {
+		t.Errorf("Calls of PlayFile() = %q", calls)
+	}
+
+	// Stopped with something loaded still means nothing is playing
+	player.set("org.mpris.MediaPlayer2.Player.Metadata", map[string]dbus.Variant{
+		"xesam:url":    dbus.MakeVariant("file:///videos/A%20Movie.mkv"),
+		"mpris:length": dbus.MakeVariant(int64(5400 * 1000000)),
+	})
+	player.set("org.mpris.MediaPlayer2.Player.Position", int64(90*1000000))
+	status, err := mpris.PlaybackStatus()
+	if err != nil || status != (media_player.PlaybackStatus{}) {
+		t.Errorf("PlaybackStatus() when stopped = %+v, %v", status, err)
+	}
+	player.set("org.mpris.MediaPlayer2.Player.PlaybackStatus", "Paused")
+	want := media_player.PlaybackStatus{File: "/videos/A Movie.mkv", Position: 90, Length: 5400}
+	if status, err = mpris.PlaybackStatus(); err != nil || status != want {
+		t.Errorf("PlaybackStatus() = %+v, %v, want %+v", status, err, want)
+	}
+
+	// Files are added after the last track
+	if err = mpris.Enqueue("/videos/E01.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	player.set("org.mpris.MediaPlayer2.TrackList.Tracks", []dbus.ObjectPath{"/track/1", "/track/2"})
+	player.set_url("/track/1", "file:///videos/E01.mkv")
+	player.set_url("/track/2", "file:///videos/E02.mkv")
+	if err = mpris.Enqueue("/videos/E02.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	want_calls := []string{
+		"AddTrack file:///videos/E01.mkv /org/mpris/MediaPlayer2/TrackList/NoTrack",
+		"AddTrack file:///videos/E02.mkv /track/2",
+	}
+	if calls := player.take_calls(); !reflect.DeepEqual(calls, want_calls) {
+		t.Errorf("Calls of Enqueue() = %q, want %q", calls, want_calls)
+	}
+	files, err := mpris.PlayerPlaylist()
+	if err != nil || !reflect.DeepEqual(files, []string{"/videos/E01.mkv", "/videos/E02.mkv"}) {
+		t.Errorf("PlayerPlaylist() = %q, %v", files, err)
+	}
+
+	player.set("org.mpris.MediaPlayer2.HasTrackList", false)
+	if err = mpris.Enqueue("/videos/E03.mkv"); err == nil {
+		t.Errorf("Enqueue() to a player without a track list succeeded")
+	}
+}
+
+func TestMPRISCapabilities(t *testing.T) {
+	address, stop := start_dbus(t)
+	defer stop()
+	player, conn := export_fake_mpris(t, address, "fake")
+	defer conn.Close()
+	mp, _ := media_player.CreateMPRIS(media_player.MPRISInfo{Bus: address})
+	mpris := mp.(*media_player.MPRIS)
+
+	all := media_player.CapPause | media_player.CapStop | media_player.CapNext | media_player.CapPrevious |
+		media_player.CapSeek | media_player.CapVolume | media_player.CapFullscreen
+	if caps := mpris.Capabilities(); caps != all {
+		t.Errorf("Capabilities() = %s, want %s", caps, all)
+	}
+
+	player.set("org.mpris.MediaPlayer2.CanSetFullscreen", false)
+	player.set("org.mpris.MediaPlayer2.Player.CanSeek", false)
+	player.set("org.mpris.MediaPlayer2.Player.CanGoNext", false)
+	want := media_player.CapPause | media_player.CapStop | media_player.CapPrevious | media_player.CapVolume
+	if caps := mpris.Capabilities(); caps != want {
+		t.Errorf("Capabilities() = %s, want %s", caps, want)
+	}
+	_, err := media_player.GetControl(mpris, media_player.CapSeek)
+	if _, not_supported := err.(*media_player.NotSupportedError); !not_supported {
+		t.Errorf("GetControl() of a player that can't seek returned %v", err)
+	}
+
+	// A player that can't be controlled can't do any of it
+	player.set("org.mpris.MediaPlayer2.Player.CanControl", false)
+	if caps := mpris.Capabilities(); caps != 0 {
+		t.Errorf("Capabilities() of a player that can't be controlled = %s", caps)
+	}
+}
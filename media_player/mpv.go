@@ -0,0 +1,356 @@
+package media_player
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/chrigrah/nextplz/backend"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	mpv_ipc_timeout = 2 * time.Second
+)
+
+type MPV struct {
+	executable  string
+	socket_path string
+
+	lock sync.Mutex
+	ipc  *mpv_ipc_conn
+}
+
+func CreateMPV(socket_path string) (MediaPlayer, error) {
+	executable, err := exec.LookPath("mpv")
+	if err != nil {
+		return nil, err
+	}
+
+	if socket_path == "" {
+		socket_path = DefaultMPVSocketPath()
+	}
+
+	return MediaPlayer(&MPV{executable: executable, socket_path: socket_path}), nil
+}
+
+// DefaultMPVSocketPath returns a socket path in the temp directory that is
+// unique per user, so that two users on the same machine don't end up
+// controlling each others players.
+func DefaultMPVSocketPath() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("nextplz-mpv-%d.sock", os.Getuid()))
+}
+
+func (mpv *MPV) TryQueue(file string) error {
+	_, err := mpv.Command("loadfile", file, "append-play")
+	return err
+}
+
+func (mpv *MPV) PlayFile(file string) error {
+	return mpv.play([]string{file}, "append-play", 0)
+}
+
+// Enqueue is the same as PlayFile, as mpv appends files to its playlist.
+func (mpv *MPV) Enqueue(file string) error {
+	return mpv.PlayFile(file)
+}
+
+// PlayFiles replaces what is playing with the first of files and queues the
+// rest after it.
+func (mpv *MPV) PlayFiles(files []string) error {
+	return mpv.play(files, "replace", 0)
+}
+
+func (mpv *MPV) PlayerPlaylist() (files []string, err error) {
+	var playlist []struct {
+		Filename string `json:"filename"`
+	}
+	if err = mpv.GetProperty("playlist", &playlist); err != nil {
+		return
+	}
+	for _, entry := range playlist {
+		files = append(files, entry.Filename)
+	}
+	return
+}
+
+func (mpv *MPV) CanStartAt() bool {
+	return true
+}
+
+func (mpv *MPV) PlayFileAt(file string, start int) error {
+	return mpv.play([]string{file}, "append-play", start)
+}
+
+// play loads the first of files into the running mpv with the loadfile
+// flags, starting at start, and appends the rest after it. Each file gets its
+// subtitle if it has one. If mpv isn't running, it is started with all of
+// them. Errors reported by a running mpv are returned as they are.
+func (mpv *MPV) play(files []string, flags string, start int) error {
+	if err := mpv.loadfile(files[0], flags, mpv_file_options(files[0], start)); err != nil {
+		if _, is_mpv_err := err.(*MPVError); is_mpv_err {
+			return err
+		}
+		return mpv.spawn(files, start)
+	}
+	for _, file := range files[1:] {
+		if err := mpv.loadfile(file, "append", mpv_file_options(file, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mpv *MPV) loadfile(file, flags string, options map[string]string) error {
+	if len(options) == 0 {
+		_, err := mpv.Command("loadfile", file, flags)
+		return err
+	}
+	_, err := mpv.command(map[string]interface{}{
+		"name":    "loadfile",
+		"url":     file,
+		"flags":   flags,
+		"options": options,
+	})
+	return err
+}
+
+// spawn starts mpv with files, the first of them starting at start.
+func (mpv *MPV) spawn(files []string, start int) error {
+	args := []string{"--input-ipc-server=" + mpv.socket_path, "--force-window", "--keep-open=no"}
+	for i, file := range files {
+		if i > 0 {
+			start = 0
+		}
+		options := mpv_file_options(file, start)
+		if len(options) == 0 {
+			args = append(args, file)
+			continue
+		}
+		// --{ --} scope the options to the file
+		args = append(args, "--{")
+		for _, name := range []string{"start", "sub-files"} {
+			if value, ok := options[name]; ok {
+				args = append(args, fmt.Sprintf("--%s=%s", name, value))
+			}
+		}
+		args = append(args, file, "--}")
+	}
+	_, err := GlobalSupervisor.Start(exec.Command(mpv.executable, args...))
+	return err
+}
+
+func mpv_file_options(file string, start int) map[string]string {
+	options := make(map[string]string)
+	if start > 0 {
+		options["start"] = strconv.Itoa(start)
+	}
+	if subs := backend.PreferredSubtitle(file); subs != "" {
+		options["sub-files"] = subs
+	}
+	return options
+}
+
+func (mpv *MPV) PlaybackStatus() (status PlaybackStatus, err error) {
+	if err = mpv.GetProperty("path", &status.File); err != nil {
+		if _, is_mpv_err := err.(*MPVError); is_mpv_err {
+			err = nil // Property unavailable, nothing is loaded
+		} else if op_err, ok := err.(*net.OpError); ok && op_err.Op == "dial" {
+			err = nil // No mpv listening, it has exited
+		}
+		return PlaybackStatus{}, err
+	}
+
+	var position, length float64
+	var paused bool
+	if err = mpv.GetProperty("time-pos", &position); err != nil {
+		return
+	}
+	mpv.GetProperty("duration", &length) // Unavailable for some streams
+	if err = mpv.GetProperty("pause", &paused); err != nil {
+		return
+	}
+	status.Position = int(position)
+	status.Length = int(length)
+	status.Playing = !paused
+	return
+}
+
+func (mpv *MPV) Capabilities() Capability {
+	return CapPause | CapStop | CapNext | CapPrevious | CapSeek | CapVolume | CapFullscreen
+}
+
+func (mpv *MPV) Pause() error {
+	_, err := mpv.Command("cycle", "pause")
+	return err
+}
+
+func (mpv *MPV) Stop() error {
+	_, err := mpv.Command("stop")
+	return err
+}
+
+func (mpv *MPV) Next() error {
+	_, err := mpv.Command("playlist-next")
+	return err
+}
+
+func (mpv *MPV) Previous() error {
+	_, err := mpv.Command("playlist-prev")
+	return err
+}
+
+func (mpv *MPV) Seek(seconds int) error {
+	_, err := mpv.Command("seek", seconds, "relative")
+	return err
+}
+
+func (mpv *MPV) ChangeVolume(percent int) error {
+	_, err := mpv.Command("add", "volume", percent)
+	return err
+}
+
+func (mpv *MPV) ToggleFullscreen() error {
+	_, err := mpv.Command("cycle", "fullscreen")
+	return err
+}
+
+// GetProperty reads the mpv property name and unmarshals it into value.
+func (mpv *MPV) GetProperty(name string, value interface{}) error {
+	data, err := mpv.Command("get_property", name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (mpv *MPV) SetProperty(name string, value interface{}) error {
+	_, err := mpv.Command("set_property", name, value)
+	return err
+}
+
+// Command sends a command to the running mpv instance and returns the data
+// field of the reply. A broken connection is dropped so that the next command
+// reconnects, possibly to a newly started mpv.
+func (mpv *MPV) Command(args ...interface{}) (json.RawMessage, error) {
+	return mpv.command(args)
+}
+
+// command sends either a list of arguments or a map of named arguments.
+func (mpv *MPV) command(command interface{}) (data json.RawMessage, err error) {
+	mpv.lock.Lock()
+	defer mpv.lock.Unlock()
+	defer func() { log_mpv_command(command, data, err) }()
+
+	if mpv.ipc == nil {
+		ipc, err := dial_mpv_ipc(mpv.socket_path)
+		if err != nil {
+			return nil, err
+		}
+		mpv.ipc = ipc
+	}
+
+	data, err = mpv.ipc.command(command)
+	if _, is_mpv_err := err.(*MPVError); err != nil && !is_mpv_err {
+		mpv.ipc.close()
+		mpv.ipc = nil
+	}
+	return data, err
+}
+
+func log_mpv_command(command interface{}, data json.RawMessage, err error) {
+	var name interface{}
+	switch command := command.(type) {
+	case []interface{}:
+		if len(command) > 0 {
+			name = command[0]
+		}
+	case map[string]interface{}:
+		name = command["name"]
+	}
+	request, _ := json.Marshal(command)
+	query := name == "get_property"
+	var reply []string
+	if len(data) > 0 && !query && string(data) != "null" {
+		reply = []string{string(data)}
+	}
+	log_command("mpv ipc", string(request), query, reply, err)
+}
+
+// MPVError is an error reported by mpv itself, as opposed to an error in
+// the communication with it.
+type MPVError struct {
+	Command interface{}
+	Message string
+}
+
+func (err *MPVError) Error() string {
+	return fmt.Sprintf("mpv: %v: %s", err.Command, err.Message)
+}
+
+type mpv_request struct {
+	Command   interface{} `json:"command"`
+	RequestID int         `json:"request_id"`
+}
+
+type mpv_reply struct {
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+	RequestID int             `json:"request_id"`
+	Event     string          `json:"event"`
+}
+
+type mpv_ipc_conn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	request_id int
+}
+
+func dial_mpv_ipc(socket_path string) (*mpv_ipc_conn, error) {
+	conn, err := net.DialTimeout("unix", socket_path, mpv_ipc_timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &mpv_ipc_conn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (ipc *mpv_ipc_conn) command(command interface{}) (json.RawMessage, error) {
+	ipc.request_id++
+	request, err := json.Marshal(mpv_request{Command: command, RequestID: ipc.request_id})
+	if err != nil {
+		return nil, err
+	}
+
+	ipc.conn.SetDeadline(time.Now().Add(mpv_ipc_timeout))
+	if _, err = ipc.conn.Write(append(request, '\n')); err != nil {
+		return nil, err
+	}
+
+	for {
+		line, err := ipc.reader.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		var reply mpv_reply
+		if err = json.Unmarshal(line, &reply); err != nil {
+			return nil, err
+		}
+		if reply.Event != "" || reply.RequestID != ipc.request_id {
+			continue // Events and stale replies
+		}
+		if reply.Error != "success" {
+			return nil, &MPVError{command, reply.Error}
+		}
+		return reply.Data, nil
+	}
+}
+
+func (ipc *mpv_ipc_conn) close() error {
+	return ipc.conn.Close()
+}
@@ -0,0 +1,102 @@
+package media_player
+
+import (
+	"time"
+)
+
+const (
+	// A file counts as played to the end if it stopped this close to its end.
+	// Players are polled, so the last seen position lags behind a bit.
+	finished_margin = 5
+)
+
+// PlaybackStatus is what a player is doing. File is empty when the player is
+// idle or gone.
+type PlaybackStatus struct {
+	File     string
+	Position int // Seconds
+	Length   int // Seconds, 0 if unknown
+	Playing  bool
+}
+
+// Finished reports whether the status is that of a file played to its end.
+func (status PlaybackStatus) Finished() bool {
+	return status.Length > 0 && status.Position >= status.Length-finished_margin
+}
+
+// StatusReporter is implemented by players that can tell what they are
+// playing.
+type StatusReporter interface {
+	PlaybackStatus() (PlaybackStatus, error)
+}
+
+// StartAtPlayer is implemented by players that can start playback at an
+// offset, in seconds.
+type StartAtPlayer interface {
+	CanStartAt() bool
+	PlayFileAt(file string, start int) error
+}
+
+func CanStartAt(mp MediaPlayer) bool {
+	sap, ok := mp.(StartAtPlayer)
+	return ok && sap.CanStartAt()
+}
+
+// PlaybackTracker polls the current media player and calls OnStop whenever the
+// player stops playing a file, be it because the file ended, another file
+// was started, or the player went away. last is the final status seen for
+// the file. If the file was played to its end and the player went idle or
+// away, rather than on to another file, OnEnd is called after OnStop.
+type PlaybackTracker struct {
+	OnStop func(last PlaybackStatus)
+	OnEnd  func(last PlaybackStatus)
+
+	interval time.Duration
+	player   MediaPlayer // The one that last is from
+	last     PlaybackStatus
+}
+
+func StartPlaybackTracker(interval time.Duration, on_stop, on_end func(last PlaybackStatus)) *PlaybackTracker {
+	tracker := &PlaybackTracker{OnStop: on_stop, OnEnd: on_end, interval: interval}
+	go tracker.run()
+	return tracker
+}
+
+func (tracker *PlaybackTracker) run() {
+	for _ = range time.Tick(tracker.interval) {
+		tracker.poll()
+	}
+}
+
+// poll asks the current media player what it is doing. A poll that fails,
+// like on a timeout, tells nothing about whether the file stopped, so it is
+// skipped.
+func (tracker *PlaybackTracker) poll() {
+	player := CurrentMediaPlayer()
+	reporter, ok := player.(StatusReporter)
+	if !ok {
+		return
+	}
+	status, err := reporter.PlaybackStatus()
+	if err != nil {
+		return
+	}
+	tracker.observe(player, status)
+}
+
+// observe compares status with the last one seen. When the current player
+// has changed, the file of the previous one may still be playing, so the
+// tracking starts over without calling anything.
+func (tracker *PlaybackTracker) observe(player MediaPlayer, status PlaybackStatus) {
+	if player != tracker.player {
+		tracker.player, tracker.last = player, status
+		return
+	}
+	if tracker.last.File != "" && tracker.last.File != status.File {
+		tracker.OnStop(tracker.last)
+		if status.File == "" && tracker.last.Finished() && tracker.OnEnd != nil {
+			tracker.OnEnd(tracker.last)
+		}
+	}
+	tracker.last = status
+}
@@ -0,0 +1,77 @@
+package media_player
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// status_player is a player that reports the status, or the error, it is
+// given.
+type status_player struct {
+	status PlaybackStatus
+	err    error
+}
+
+func (player *status_player) PlayFile(file string) error {
+	return nil
+}
+
+func (player *status_player) PlaybackStatus() (PlaybackStatus, error) {
+	return player.status, player.err
+}
+
+// recording_tracker returns a tracker that records the files it is called
+// for, like stop E01 or end E01.
+func recording_tracker(calls *[]string) *PlaybackTracker {
+	return &PlaybackTracker{
+		OnStop: func(last PlaybackStatus) { *calls = append(*calls, "stop "+last.File) },
+		OnEnd:  func(last PlaybackStatus) { *calls = append(*calls, "end "+last.File) },
+	}
+}
+
+func TestPlaybackTrackerSkipsFailedPolls(t *testing.T) {
+	defer SetMediaPlayer(CurrentMediaPlayer())
+	player := &status_player{status: PlaybackStatus{File: "E01", Position: 2600, Length: 2700, Playing: true}}
+	SetMediaPlayer(player)
+	var calls []string
+	tracker := recording_tracker(&calls)
+
+	tracker.poll()
+	player.status.Position = 2698
+	tracker.poll()
+	// A timeout near the end is not the end
+	player.err = errors.New("Timeout")
+	tracker.poll()
+	player.err = nil
+	tracker.poll()
+	if len(calls) != 0 {
+		t.Fatalf("A failed poll made the tracker call %q", calls)
+	}
+
+	player.status = PlaybackStatus{}
+	tracker.poll()
+	if want := []string{"stop E01", "end E01"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Calls after the player went idle = %q, want %q", calls, want)
+	}
+}
+
+func TestPlaybackTrackerFollowsThePlayer(t *testing.T) {
+	defer SetMediaPlayer(CurrentMediaPlayer())
+	first := &status_player{status: PlaybackStatus{File: "Movie", Position: 5395, Length: 5400, Playing: true}}
+	second := &status_player{status: PlaybackStatus{File: "E01", Position: 10, Length: 2700, Playing: true}}
+	SetMediaPlayer(first)
+	var calls []string
+	tracker := recording_tracker(&calls)
+
+	tracker.poll()
+	tracker.poll()
+	// Another player, like one picked with Open with, doesn't stop the movie
+	SetMediaPlayer(second)
+	tracker.poll()
+	second.status = PlaybackStatus{}
+	tracker.poll()
+	if want := []string{"stop E01"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Calls = %q, want %q", calls, want)
+	}
+}
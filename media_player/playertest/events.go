@@ -0,0 +1,41 @@
+package playertest
+
+import (
+	"github.com/chrigrah/nextplz/gadgets"
+	"github.com/nsf/termbox-go"
+)
+
+// Key returns the event of pressing key.
+func Key(key termbox.Key) termbox.Event {
+	return termbox.Event{Type: termbox.EventKey, Key: key}
+}
+
+// Type returns the events of typing text.
+func Type(text string) (events []termbox.Event) {
+	for _, ch := range text {
+		events = append(events, termbox.Event{Type: termbox.EventKey, Ch: ch})
+	}
+	return
+}
+
+// Drive feeds events to ir the way main does for the focused gadget: Enter
+// finalizes it and Escape is offered to it before it would be closed. It
+// stops at the first error, which is returned.
+func Drive(ir gadgets.InputReceiver, events ...termbox.Event) error {
+	for _, event := range events {
+		if event.Ch == 0 {
+			switch event.Key {
+			case termbox.KeyEnter:
+				if status := ir.Finalize(); status.Chain != nil {
+					return status.Chain
+				}
+			case termbox.KeyEsc:
+				ir.HandleEscape()
+			}
+		}
+		if err := ir.Input(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package playertest
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/chrigrah/nextplz/media_player"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	rc_greeting = "VLC media player 3.0.0 Vetinari\nCommand Line Interface initialized. Type `help' for help.\n"
+	rc_prompt   = "> "
+)
+
+// RCServer is a fake VLC remote control interface listening on a local
+// port. It records the commands it receives and answers them like VLC does.
+// Files added with add or enqueue make up its playlist, and its status
+// follows what it is told to do, so that a VLC attached to it behaves like it
+// is playing.
+type RCServer struct {
+	listener net.Listener
+
+	lock     sync.Mutex
+	commands []string
+	replies  map[string][]string
+	playlist []string
+	current  int // Index in playlist, -1 when stopped
+	paused   bool
+}
+
+// NewRCServer starts an RCServer on a random port of 127.0.0.1.
+func NewRCServer() (*RCServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &RCServer{listener: listener, replies: make(map[string][]string), current: -1}
+	go s.serve()
+	return s, nil
+}
+
+// Endpoint returns an endpoint that attaches a VLC to the server, as in
+// media_player.CreateVLC(server.Endpoint()).
+func (s *RCServer) Endpoint() media_player.RCEndpoint {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return media_player.RCEndpoint{Host: addr.IP.String(), Port: addr.Port, Attach: true}
+}
+
+// Commands returns the commands received so far, oldest first.
+func (s *RCServer) Commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// SetReply makes the server answer command, its first word, with lines
+// instead of the default reply. Replies starting like VLC's errors, such as
+// "Unknown command", make the command fail.
+func (s *RCServer) SetReply(command string, lines ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.replies[command] = lines
+}
+
+func (s *RCServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *RCServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *RCServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	fmt.Fprint(conn, rc_greeting+rc_prompt)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "quit" || command == "logout" {
+			return
+		}
+		var reply string
+		for _, line := range s.exec(command) {
+			reply += line + "\n"
+		}
+		if _, err := fmt.Fprint(conn, reply+rc_prompt); err != nil {
+			return
+		}
+	}
+}
+
+// exec records command and returns the reply to it.
+func (s *RCServer) exec(command string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.commands = append(s.commands, command)
+	name, arg := command, ""
+	if i := strings.Index(command, " "); i >= 0 {
+		name, arg = command[:i], command[i+1:]
+	}
+	if reply, ok := s.replies[name]; ok {
+		return reply
+	}
+
+	switch name {
+	case "add", "enqueue":
+		// Input options follow the input, like :sub-file=...
+		items := split_rc_items(arg)
+		if len(items) == 0 {
+			return []string{"Missing argument"}
+		}
+		s.playlist = append(s.playlist, items[0])
+		if name == "add" || s.current < 0 {
+			s.current, s.paused = len(s.playlist)-1, false
+		}
+	case "pause":
+		s.paused = !s.paused
+	case "stop":
+		s.current = -1
+	case "next":
+		if s.current >= 0 && s.current < len(s.playlist)-1 {
+			s.current++
+		}
+	case "prev":
+		if s.current > 0 {
+			s.current--
+		}
+	case "clear":
+		s.playlist, s.current = nil, -1
+	case "seek", "volume", "volup", "voldown", "f", "fullscreen":
+	case "status":
+		return s.status()
+	case "is_playing":
+		if s.current >= 0 {
+			return []string{"1"}
+		}
+		return []string{"0"}
+	case "get_time", "get_length":
+		if s.current >= 0 {
+			return []string{"0"}
+		}
+		return []string{""}
+	case "get_title":
+		if s.current >= 0 {
+			return []string{filepath.Base(s.playlist[s.current])}
+		}
+		return []string{""}
+	case "playlist":
+		return s.playlist_reply()
+	default:
+		return []string{fmt.Sprintf("Unknown command `%s'. Type `help' for help.", name)}
+	}
+	return nil
+}
+
+func (s *RCServer) status() (lines []string) {
+	state := "stopped"
+	if s.current >= 0 {
+		lines = append(lines, "( new input: "+to_mrl(s.playlist[s.current])+" )")
+		state = "playing"
+		if s.paused {
+			state = "paused"
+		}
+	}
+	return append(lines, "( audio volume: 256 )", "( state "+state+" )")
+}
+
+func (s *RCServer) playlist_reply() []string {
+	lines := []string{"+----[ Playlist - playlist ]", "| 1 - Playlist"}
+	for i, file := range s.playlist {
+		marker := ""
+		if i == s.current {
+			marker = "*"
+		}
+		lines = append(lines, fmt.Sprintf("|  %s%d - %s (00:00:00)", marker, i+3, filepath.Base(file)))
+	}
+	return append(lines, "| 2 - Media Library", "+----[ End of playlist ]")
+}
+
+// split_rc_items splits the argument of add or enqueue into the input and
+// its options like VLC does, on whitespace outside of double quotes. The
+// quotes are taken off, and \" in quotes is a double quote while other
+// backslashes are kept, as in windows paths.
+func split_rc_items(arg string) (items []string) {
+	var item []rune
+	in_item, quoted, escaped := false, false, false
+	for _, c := range arg {
+		switch {
+		case escaped && c == '"':
+			item, escaped = append(item, c), false
+		case escaped:
+			item, escaped = append(item, '\\', c), false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted, in_item = !quoted, true
+		case !quoted && (c == ' ' || c == '\t'):
+			if in_item {
+				items, item, in_item = append(items, string(item)), nil, false
+			}
+		default:
+			item, in_item = append(item, c), true
+		}
+	}
+	if in_item {
+		items = append(items, string(item))
+	}
+	return
+}
+
+func to_mrl(file string) string {
+	if strings.Contains(file, "://") {
+		return file
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(file)}).String()
+}
@@ -0,0 +1,157 @@
+// Package playertest provides fake media players for exercising nextplz
+// without a real player: a Recorder that stands in for a MediaPlayer, an
+// RCServer that speaks enough of the VLC remote control interface for a VLC
+// to attach to it, and helpers that feed key presses to gadgets.
+package playertest
+
+import (
+	"fmt"
+	"github.com/chrigrah/nextplz/media_player"
+	"strings"
+	"sync"
+)
+
+// Call is a method called on a Recorder, with its arguments.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+func (call Call) String() string {
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = fmt.Sprint(arg)
+	}
+	return fmt.Sprintf("%s(%s)", call.Method, strings.Join(args, ", "))
+}
+
+// Recorder is a media player that records what it is asked to do. It
+// implements all the optional player interfaces, reports whatever status it
+// is given, and answers every call with Err.
+type Recorder struct {
+	lock   sync.Mutex
+	calls  []Call
+	status media_player.PlaybackStatus
+	err    error
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Calls returns the calls made so far, oldest first.
+func (r *Recorder) Calls() []Call {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Files returns the files handed to the player so far, in order, whether
+// they were played or enqueued.
+func (r *Recorder) Files() (files []string) {
+	for _, call := range r.Calls() {
+		switch call.Method {
+		case "PlayFile", "PlayFileAt", "Enqueue":
+			files = append(files, call.Args[0].(string))
+		case "PlayFiles":
+			files = append(files, call.Args[0].([]string)...)
+		}
+	}
+	return
+}
+
+// Reset forgets the calls made so far.
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = nil
+}
+
+// SetStatus sets what PlaybackStatus reports.
+func (r *Recorder) SetStatus(status media_player.PlaybackStatus) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.status = status
+}
+
+// SetError makes all calls fail with err, or succeed again if it is nil.
+func (r *Recorder) SetError(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.err = err
+}
+
+func (r *Recorder) record(method string, args ...interface{}) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = append(r.calls, Call{method, args})
+	return r.err
+}
+
+func (r *Recorder) PlayFile(file string) error {
+	return r.record("PlayFile", file)
+}
+
+func (r *Recorder) Enqueue(file string) error {
+	return r.record("Enqueue", file)
+}
+
+func (r *Recorder) PlayFiles(files []string) error {
+	return r.record("PlayFiles", append([]string(nil), files...))
+}
+
+func (r *Recorder) CanStartAt() bool {
+	return true
+}
+
+func (r *Recorder) PlayFileAt(file string, start int) error {
+	return r.record("PlayFileAt", file, start)
+}
+
+// PlayerPlaylist returns the files handed to the player so far.
+func (r *Recorder) PlayerPlaylist() ([]string, error) {
+	r.lock.Lock()
+	err := r.err
+	r.lock.Unlock()
+	return r.Files(), err
+}
+
+// PlaybackStatus isn't recorded, as it is polled.
+func (r *Recorder) PlaybackStatus() (media_player.PlaybackStatus, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.status, r.err
+}
+
+func (r *Recorder) Capabilities() media_player.Capability {
+	return media_player.CapPause | media_player.CapStop | media_player.CapNext | media_player.CapPrevious |
+		media_player.CapSeek | media_player.CapVolume | media_player.CapFullscreen
+}
+
+func (r *Recorder) Pause() error {
+	return r.record("Pause")
+}
+
+func (r *Recorder) Stop() error {
+	return r.record("Stop")
+}
+
+func (r *Recorder) Next() error {
+	return r.record("Next")
+}
+
+func (r *Recorder) Previous() error {
+	return r.record("Previous")
+}
+
+func (r *Recorder) Seek(seconds int) error {
+	return r.record("Seek", seconds)
+}
+
+func (r *Recorder) ChangeVolume(percent int) error {
+	return r.record("ChangeVolume", percent)
+}
+
+func (r *Recorder) ToggleFullscreen() error {
+	return r.record("ToggleFullscreen")
+}
@@ -0,0 +1,216 @@
+package media_player
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/chrigrah/nextplz/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	DEFAULT_PROFILE = "default"
+)
+
+// GlobalProfiles holds the configured players. Files are played through it
+// so that the rules pick the right player for each file.
+var GlobalProfiles *PlayerProfiles
+
+// ProfileConfig is a named player in the profiles file. The fields have the
+// same meaning as the flags of the same name.
+type ProfileConfig struct {
+	Player string `json:"player"`
+	Exe    string `json:"exe"`
+	Args   string `json:"args"`
+	Socket string `json:"mpv-socket"`
+}
+
+// RuleConfig selects Profile for files whose name matches the shell pattern
+// Match. Patterns containing a slash are matched against as many trailing
+// path components as they have, so */VIDEO_TS matches any DVD folder.
+// Matching is case insensitive.
+type RuleConfig struct {
+	Match   string `json:"match"`
+	Profile string `json:"profile"`
+}
+
+type ProfilesConfig struct {
+	Profiles map[string]ProfileConfig `json:"profiles"`
+	Rules    []RuleConfig             `json:"rules"`
+	Default  string                   `json:"default"`
+}
+
+type PlayerProfiles struct {
+	Names []string
+
+	players      map[string]MediaPlayer
+	rules        []RuleConfig
+	default_name string
+
+	lock        sync.Mutex
+	choices     map[string]string // extension -> profile
+	choice_path string
+}
+
+func DefaultProfilesPath() string {
+	return filepath.Join(util.ConfigDir(), "players.json")
+}
+
+// LoadProfiles reads the profiles file at config_path. The player created from the
+// command line flags is always available as the profile "default". A missing
+// profiles file is not an error.
+func LoadProfiles(config_path string, info *MediaPlayerInitInfo, default_player MediaPlayer) (*PlayerProfiles, error) {
+	pp := &PlayerProfiles{
+		Names:        []string{DEFAULT_PROFILE},
+		players:      map[string]MediaPlayer{DEFAULT_PROFILE: default_player},
+		default_name: DEFAULT_PROFILE,
+		choices:      make(map[string]string),
+		choice_path:  filepath.Join(filepath.Dir(config_path), "choices.json"),
+	}
+
+	var config ProfilesConfig
+	data, err := ioutil.ReadFile(config_path)
+	if os.IsNotExist(err) {
+		return pp, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %s", config_path, err)
+	}
+
+	// Checked in order of name, so that a broken file always gets the same error
+	for name := range config.Profiles {
+		pp.Names = append(pp.Names, name)
+	}
+	sort.Strings(pp.Names[1:])
+
+	for _, name := range pp.Names[1:] {
+		profile := config.Profiles[name]
+		if name == DEFAULT_PROFILE {
+			return nil, fmt.Errorf("%s: the profile name %q is reserved for the player given by flags", config_path, name)
+		}
+		profile_info := *info
+		profile_info.Player = profile.Player
+		profile_info.Executable = profile.Exe
+		profile_info.Arguments = profile.Args
+		profile_info.MPVSocket = profile.Socket
+		mp, err := profile_info.CreateMediaPlayer()
+		if err != nil {
+			return nil, fmt.Errorf("%s: profile %s: %s", config_path, name, err)
+		}
+		pp.players[name] = mp
+	}
+
+	for _, rule := range config.Rules {
+		if _, ok := pp.players[rule.Profile]; !ok {
+			return nil, fmt.Errorf("%s: rule %q refers to unknown profile %q", config_path, rule.Match, rule.Profile)
+		}
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return nil, fmt.Errorf("%s: rule %q: %s", config_path, rule.Match, err)
+		}
+		pp.rules = append(pp.rules, rule)
+	}
+
+	if config.Default != "" {
+		if _, ok := pp.players[config.Default]; !ok {
+			return nil, fmt.Errorf("%s: unknown default profile %q", config_path, config.Default)
+		}
+		pp.default_name = config.Default
+	}
+
+	if data, err := ioutil.ReadFile(pp.choice_path); err == nil {
+		json.Unmarshal(data, &pp.choices)
+	}
+
+	return pp, nil
+}
+
+// ProfileFor returns the name of the profile the rules select for file.
+func (pp *PlayerProfiles) ProfileFor(file string) string {
+	components := strings.Split(filepath.ToSlash(strings.ToLower(file)), "/")
+	for _, rule := range pp.rules {
+		pattern := filepath.ToSlash(strings.ToLower(rule.Match))
+		depth := util.Min(strings.Count(pattern, "/")+1, len(components))
+		subject := strings.Join(components[len(components)-depth:], "/")
+		if matched, _ := path.Match(pattern, subject); matched {
+			return rule.Profile
+		}
+	}
+	return pp.default_name
+}
+
+func (pp *PlayerProfiles) Get(name string) (MediaPlayer, bool) {
+	mp, ok := pp.players[name]
+	return mp, ok
+}
+
+// PlayFile plays file with the player the rules select for it.
+func (pp *PlayerProfiles) PlayFile(file string) error {
+	return pp.PlayFileWith(pp.ProfileFor(file), file)
+}
+
+// PlayFileWith plays file with the named profile. That player becomes the
+// current media player, so that the player controls act on it.
+func (pp *PlayerProfiles) PlayFileWith(name, file string) error {
+	mp, ok := pp.players[name]
+	if !ok {
+		return fmt.Errorf("Unknown player profile: %s", name)
+	}
+	SetMediaPlayer(mp)
+	return mp.PlayFile(file)
+}
+
+// CanStartAt reports whether the player file would be played with can start
+// playback at an offset.
+func (pp *PlayerProfiles) CanStartAt(file string) bool {
+	return CanStartAt(pp.players[pp.ProfileFor(file)])
+}
+
+// PlayFileAt plays file with the player the rules select for it, starting
+// start seconds in.
+func (pp *PlayerProfiles) PlayFileAt(file string, start int) error {
+	name := pp.ProfileFor(file)
+	mp := pp.players[name]
+	if !CanStartAt(mp) {
+		return fmt.Errorf("Player profile %s can't start playback at an offset", name)
+	}
+	SetMediaPlayer(mp)
+	return mp.(StartAtPlayer).PlayFileAt(file, start)
+}
+
+// LastChoice returns the profile last picked for files with the extension of
+// file, or the one the rules select if none has been picked.
+func (pp *PlayerProfiles) LastChoice(file string) string {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	if name, ok := pp.choices[strings.ToLower(filepath.Ext(file))]; ok {
+		if _, exists := pp.players[name]; exists {
+			return name
+		}
+	}
+	return pp.ProfileFor(file)
+}
+
+// RememberChoice records that name was picked for the extension of file and
+// saves it for the next session.
+func (pp *PlayerProfiles) RememberChoice(file, name string) error {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	pp.choices[strings.ToLower(filepath.Ext(file))] = name
+	data, err := json.MarshalIndent(pp.choices, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(pp.choice_path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pp.choice_path, data, 0644)
+}
@@ -0,0 +1,98 @@
+package media_player
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Enqueuer is implemented by players with a playlist that files can be
+// added to without interrupting what is playing. An idle player starts
+// playing the file.
+type Enqueuer interface {
+	Enqueue(file string) error
+}
+
+// PlaylistReader is implemented by players that can list their playlist.
+// Depending on the player, the entries are paths or just titles, which
+// default to the file name.
+type PlaylistReader interface {
+	PlayerPlaylist() ([]string, error)
+}
+
+// PlaylistPlayer is implemented by players that can be handed several files
+// at once, playing the first right away and the rest after it.
+type PlaylistPlayer interface {
+	PlayFiles(files []string) error
+}
+
+// QueueFiles hands files to the players the rules select for them, in
+// order. A player that is playing something has the files added to its
+// playlist. An idle player, or one that isn't running, is handed all of its
+// files at once with PlayFiles, so that it is only started once.
+func (pp *PlayerProfiles) QueueFiles(files []string) error {
+	for len(files) > 0 {
+		// Consecutive files for the same profile go together
+		name := pp.ProfileFor(files[0])
+		n := 1
+		for n < len(files) && pp.ProfileFor(files[n]) == name {
+			n++
+		}
+		if err := pp.queue_files(pp.players[name], files[:n]); err != nil {
+			return err
+		}
+		files = files[n:]
+	}
+	return nil
+}
+
+func (pp *PlayerProfiles) queue_files(mp MediaPlayer, files []string) error {
+	enqueuer, ok := mp.(Enqueuer)
+	if !ok || !is_playing_something(mp) {
+		return pp.PlayFiles(files)
+	}
+	SetMediaPlayer(mp)
+	for _, file := range files {
+		if err := enqueuer.Enqueue(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// is_playing_something reports whether mp is running and has a file loaded,
+// as far as it can tell.
+func is_playing_something(mp MediaPlayer) bool {
+	reporter, ok := mp.(StatusReporter)
+	if !ok {
+		return false
+	}
+	status, err := reporter.PlaybackStatus()
+	return err == nil && status.File != ""
+}
+
+// PlayFiles plays files in order with the player the rules select for the
+// first of them. Players that can't take several files at once are handed the
+// first one and have the rest queued after it.
+func (pp *PlayerProfiles) PlayFiles(files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	mp := pp.players[pp.ProfileFor(files[0])]
+	SetMediaPlayer(mp)
+	if playlist_player, ok := mp.(PlaylistPlayer); ok {
+		return playlist_player.PlayFiles(files)
+	}
+	if err := mp.PlayFile(files[0]); err != nil {
+		return err
+	}
+	enqueuer, ok := mp.(Enqueuer)
+	if !ok && len(files) > 1 {
+		return fmt.Errorf("The player can't queue files, only %s was played", filepath.Base(files[0]))
+	}
+	for _, file := range files[1:] {
+		if err := enqueuer.Enqueue(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
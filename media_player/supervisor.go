@@ -0,0 +1,249 @@
+package media_player
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	stderr_tail_size = 4096
+
+	// Exited processes are remembered for their output
+	recent_processes = 8
+)
+
+// GlobalSupervisor starts and reaps all player processes. main replaces it
+// with one that reports to the UI.
+var GlobalSupervisor *Supervisor = NewSupervisor(nil)
+
+// ProcessError describes a player process that could not be started or that
+// exited with a non-zero status.
+type ProcessError struct {
+	Name       string
+	Err        error
+	StderrTail string
+}
+
+func (err *ProcessError) Error() string {
+	lines := strings.Split(strings.TrimSpace(err.StderrTail), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return fmt.Sprintf("%s: %s: %s", err.Name, err.Err, last)
+	}
+	return fmt.Sprintf("%s: %s", err.Name, err.Err)
+}
+
+type Process struct {
+	Name string
+	Cmd  *exec.Cmd
+
+	stderr tail_buffer
+	output *LogRing
+	done   chan struct{}
+	err    error
+
+	terminated bool
+}
+
+// String names the process by executable and pid, like vlc[1234].
+func (p *Process) String() string {
+	if p.Cmd.Process == nil {
+		return p.Name
+	}
+	return fmt.Sprintf("%s[%d]", p.Name, p.Cmd.Process.Pid)
+}
+
+// Done is closed once the process has exited and been reaped.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *Process) Running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// ExitError is the result of Wait. Only valid once Done is closed.
+func (p *Process) ExitError() error {
+	return p.err
+}
+
+func (p *Process) StderrTail() string {
+	return p.stderr.String()
+}
+
+// Output keeps the last lines the process printed. Players that read the
+// stdout of their process themselves may write what they don't use to it.
+func (p *Process) Output() *LogRing {
+	return p.output
+}
+
+type Supervisor struct {
+	KillOnExit bool
+
+	update_chan chan int
+	lock        sync.Mutex
+	processes   map[*Process]bool
+	recent      []*Process // Most recently started last
+	failures    []error
+}
+
+func NewSupervisor(update_chan chan int) *Supervisor {
+	return &Supervisor{update_chan: update_chan, processes: make(map[*Process]bool)}
+}
+
+// Start starts cmd and reaps it when it exits. Failures to start are returned,
+// while non-zero exits are queued for PopFailures and announced on the update
+// channel.
+func (s *Supervisor) Start(cmd *exec.Cmd) (*Process, error) {
+	return s.StartMasked(cmd)
+}
+
+// StartMasked is Start for commands with secrets in their arguments, which
+// are masked in the player log. The values of options named like password
+// are always masked.
+func (s *Supervisor) StartMasked(cmd *exec.Cmd, secrets ...string) (*Process, error) {
+	p := &Process{
+		Name:   filepath.Base(cmd.Path),
+		Cmd:    cmd,
+		output: NewLogRing(filepath.Base(cmd.Path), process_log_size),
+		done:   make(chan struct{}),
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = p.output
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = io.MultiWriter(&p.stderr, p.output)
+	}
+
+	PlayerLog.Add(process_log_source, "$ "+command_line(mask_args(cmd.Args, secrets)))
+	if err := cmd.Start(); err != nil {
+		perr := &ProcessError{Name: p.Name, Err: err}
+		PlayerLog.Add(process_log_source, perr.Error())
+		return nil, perr
+	}
+	p.output.SetSource(p.String())
+	PlayerLog.Addf(process_log_source, "%s started", p)
+
+	s.lock.Lock()
+	s.processes[p] = true
+	s.recent = append(s.recent, p)
+	if len(s.recent) > recent_processes {
+		s.recent = s.recent[1:]
+	}
+	s.lock.Unlock()
+
+	go s.reap(p)
+	return p, nil
+}
+
+// mask_args returns args with secrets, and the values of password options
+// like --http-password, replaced by ***.
+func mask_args(args []string, secrets []string) []string {
+	masked := make([]string, len(args))
+	mask_next := false
+	for i, arg := range args {
+		name := strings.ToLower(strings.SplitN(arg, "=", 2)[0])
+		is_password := strings.HasPrefix(name, "-") && strings.HasSuffix(name, "password")
+		switch {
+		case mask_next:
+			arg = "***"
+		case is_password && strings.Contains(arg, "="):
+			arg = arg[:strings.Index(arg, "=")+1] + "***"
+		}
+		mask_next = is_password && !strings.Contains(args[i], "=")
+		for _, secret := range secrets {
+			if secret != "" {
+				arg = strings.Replace(arg, secret, "***", -1)
+			}
+		}
+		masked[i] = arg
+	}
+	return masked
+}
+
+func (s *Supervisor) reap(p *Process) {
+	p.err = p.Cmd.Wait()
+
+	s.lock.Lock()
+	delete(s.processes, p)
+	terminated := p.terminated
+	s.lock.Unlock()
+
+	close(p.done)
+	if p.err != nil {
+		PlayerLog.Addf(process_log_source, "%s exited: %s", p, p.err)
+	} else {
+		PlayerLog.Addf(process_log_source, "%s exited", p)
+	}
+	if p.err != nil && !terminated {
+		s.report(&ProcessError{p.Name, p.err, p.stderr.String()})
+	}
+}
+
+func (s *Supervisor) report(err error) {
+	s.lock.Lock()
+	s.failures = append(s.failures, err)
+	s.lock.Unlock()
+
+	select {
+	case s.update_chan <- 1:
+	default: // An update is already pending, or nobody is listening
+	}
+}
+
+// PopFailures returns and forgets the failures reported since the last call.
+func (s *Supervisor) PopFailures() (failures []error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	failures, s.failures = s.failures, nil
+	return
+}
+
+// Shutdown terminates all running players if KillOnExit is set.
+func (s *Supervisor) Shutdown() {
+	if !s.KillOnExit {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for p := range s.processes {
+		p.terminated = true
+		if err := p.Cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			p.Cmd.Process.Kill()
+		}
+	}
+}
+
+// tail_buffer is an io.Writer that keeps the last stderr_tail_size bytes
+// written to it.
+type tail_buffer struct {
+	lock sync.Mutex
+	data []byte
+}
+
+func (tb *tail_buffer) Write(p []byte) (int, error) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	tb.data = append(tb.data, p...)
+	if len(tb.data) > stderr_tail_size {
+		tb.data = tb.data[len(tb.data)-stderr_tail_size:]
+	}
+	return len(p), nil
+}
+
+func (tb *tail_buffer) String() string {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	return string(tb.data)
+}
@@ -0,0 +1,206 @@
+package media_player
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TemplateVars are the values substituted for the placeholders of a
+// CommandTemplate.
+type TemplateVars struct {
+	File     string
+	Subs     string
+	Start    int // Seconds, 0 to start from the beginning
+	Playlist string
+}
+
+var template_placeholders = map[string]func(TemplateVars) string{
+	"file": func(v TemplateVars) string { return v.File },
+	"dir":  func(v TemplateVars) string { return filepath.Dir(v.File) },
+	"name": func(v TemplateVars) string {
+		base := filepath.Base(v.File)
+		return strings.TrimSuffix(base, filepath.Ext(base))
+	},
+	"subs": func(v TemplateVars) string { return v.Subs },
+	"start": func(v TemplateVars) string {
+		if v.Start <= 0 {
+			return ""
+		}
+		return strconv.Itoa(v.Start)
+	},
+	"playlist": func(v TemplateVars) string { return v.Playlist },
+}
+
+type TemplateError struct {
+	Template string
+	Column   int
+	Message  string
+}
+
+func (err *TemplateError) Error() string {
+	return fmt.Sprintf("invalid command template %q: column %d: %s", err.Template, err.Column, err.Message)
+}
+
+type template_part struct {
+	literal     string
+	placeholder string
+}
+
+type template_word []template_part
+
+// CommandTemplate is a player command line with shell style quoting and
+// {placeholder}s. Words are separated by unquoted whitespace. Single quotes
+// preserve everything literally, double quotes and backslashes work as in
+// sh, and {{ and }} produce literal braces. A word containing a placeholder
+// that expands to nothing is left out entirely, so --sub-file={subs} simply
+// disappears when there are no subtitles.
+type CommandTemplate struct {
+	source string
+	words  []template_word
+}
+
+func ParseCommandTemplate(source string) (*CommandTemplate, error) {
+	tmpl := &CommandTemplate{source: source}
+	runes := []rune(source)
+
+	var word template_word
+	var literal []rune
+	in_word := false
+	quote := rune(0)
+	quote_at := 0
+
+	fail := func(at int, format string, args ...interface{}) (*CommandTemplate, error) {
+		return nil, &TemplateError{source, at + 1, fmt.Sprintf(format, args...)}
+	}
+	flush_literal := func() {
+		if len(literal) > 0 {
+			word = append(word, template_part{literal: string(literal)})
+			literal = literal[:0]
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				literal = append(literal, c)
+			}
+			continue
+		case c == '\\' && (quote == 0 || (i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]))):
+			if i+1 >= len(runes) {
+				return fail(i, "trailing backslash")
+			}
+			i++
+			literal = append(literal, runes[i])
+		case c == '"':
+			if quote == '"' {
+				quote = 0
+			} else {
+				quote, quote_at = '"', i
+			}
+		case c == '\'' && quote == 0:
+			quote, quote_at = '\'', i
+		case (c == '{' || c == '}') && i+1 < len(runes) && runes[i+1] == c:
+			i++
+			literal = append(literal, c)
+		case c == '{':
+			end := i + 1
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end == len(runes) {
+				return fail(i, "unterminated placeholder")
+			}
+			name := string(runes[i+1 : end])
+			if _, ok := template_placeholders[name]; !ok {
+				return fail(i, "unknown placeholder {%s}, known placeholders are %s", name, known_placeholders())
+			}
+			flush_literal()
+			word = append(word, template_part{placeholder: name})
+			i = end
+		case c == '}':
+			return fail(i, "unmatched }, use }} for a literal brace")
+		case quote == 0 && (c == ' ' || c == '\t' || c == '\n'):
+			if in_word {
+				flush_literal()
+				tmpl.words = append(tmpl.words, word)
+				word = nil
+				in_word = false
+			}
+			continue
+		default:
+			literal = append(literal, c)
+		}
+		in_word = true
+	}
+
+	if quote != 0 {
+		return fail(quote_at, "unterminated %c quote", quote)
+	}
+	if in_word {
+		flush_literal()
+		tmpl.words = append(tmpl.words, word)
+	}
+	return tmpl, nil
+}
+
+func known_placeholders() string {
+	names := make([]string, 0, len(template_placeholders))
+	for name := range template_placeholders {
+		names = append(names, "{"+name+"}")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Uses reports whether the template contains the placeholder name.
+func (tmpl *CommandTemplate) Uses(name string) bool {
+	for _, word := range tmpl.words {
+		for _, part := range word {
+			if part.placeholder == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AppendPlaceholder adds a word consisting of a single placeholder at the
+// end of the template.
+func (tmpl *CommandTemplate) AppendPlaceholder(name string) {
+	tmpl.words = append(tmpl.words, template_word{{placeholder: name}})
+}
+
+func (tmpl *CommandTemplate) Expand(vars TemplateVars) []string {
+	args := make([]string, 0, len(tmpl.words))
+	for _, word := range tmpl.words {
+		var value []string
+		dropped := false
+		for _, part := range word {
+			if part.placeholder == "" {
+				value = append(value, part.literal)
+				continue
+			}
+			expansion := template_placeholders[part.placeholder](vars)
+			if expansion == "" {
+				dropped = true
+				break
+			}
+			value = append(value, expansion)
+		}
+		if !dropped {
+			args = append(args, strings.Join(value, ""))
+		}
+	}
+	return args
+}
+
+func (tmpl *CommandTemplate) String() string {
+	return tmpl.source
+}
@@ -0,0 +1,405 @@
+package media_player
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chrigrah/nextplz/backend"
+	"github.com/chrigrah/nextplz/util"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoSessionVLC = errors.New("No VLC has been started by this nextplz session")
+
+	vlc_playlist_item_re   = regexp.MustCompile(`^\|(\s*)\*?\d+ - (.*)$`)
+	vlc_playlist_suffix_re = regexp.MustCompile(` \(\d+:\d\d:\d\d\)( \[played \d+ times?\])?$`)
+)
+
+// RCEndpoint describes where the remote control interface of a VLC started by
+// nextplz should listen. Unless Attach is set, commands are only ever sent to
+// a VLC started by this session.
+type RCEndpoint struct {
+	Host   string
+	Port   int
+	Unix   string
+	Attach bool
+	// Private makes each VLC listen on a unix socket in a directory of its
+	// own, which no other user can get at. Host, Port and Unix are unused.
+	Private bool
+}
+
+func (ep RCEndpoint) String() string {
+	if ep.Unix != "" {
+		return ep.Unix
+	}
+	return net.JoinHostPort(ep.Host, strconv.Itoa(ep.Port))
+}
+
+type VLC struct {
+	executable string
+	endpoint   RCEndpoint
+
+	lock      sync.Mutex
+	rc        *RCClient // Connected to the VLC owned by this session
+	owned     *Process
+	running   bool
+	attach_rc *RCClient
+}
+
+func CreateVLC(endpoint RCEndpoint) (MediaPlayer, error) {
+	executable, err := exec.LookPath("vlc")
+	if err != nil {
+		return nil, err
+	}
+
+	return MediaPlayer(NewVLC(executable, endpoint)), nil
+}
+
+func NewVLC(executable string, endpoint RCEndpoint) *VLC {
+	vlc := &VLC{executable: executable, endpoint: endpoint}
+	if endpoint.Attach && endpoint.Unix != "" {
+		vlc.attach_rc = NewRCClient("unix", endpoint.Unix)
+	} else if endpoint.Attach && endpoint.Port != 0 {
+		vlc.attach_rc = NewRCClient("tcp", endpoint.String())
+	}
+	return vlc
+}
+
+func (vlc *VLC) TryQueue(file string) error {
+	rc, err := vlc.RC()
+	if err != nil {
+		return err
+	}
+	_, err = rc.Exec("add " + file)
+	return err
+}
+
+func (vlc *VLC) PlayFile(file string) error {
+	return vlc.play("add", []string{file})
+}
+
+// Enqueue adds file to the playlist without interrupting playback, starting
+// VLC if need be.
+func (vlc *VLC) Enqueue(file string) error {
+	return vlc.play("enqueue", []string{file})
+}
+
+// PlayFiles plays the first of files and queues the rest after it.
+func (vlc *VLC) PlayFiles(files []string) error {
+	return vlc.play("add", files)
+}
+
+// play adds the first of files to the playlist with command and the given
+// input options, and enqueues the rest, each along with its subtitle if it
+// has one. If this session has no VLC running, one is started with all of
+// them.
+func (vlc *VLC) play(command string, files []string, options ...string) error {
+	err := vlc.exec(rc_command(command, files[0], append(options, subtitle_options(files[0])...)))
+	if err == nil {
+		for _, file := range files[1:] {
+			if err = vlc.exec(rc_command("enqueue", file, subtitle_options(file))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, refused := err.(*RCError); refused {
+		return err
+	}
+	if vlc.is_running() {
+		// Most likely still starting up, a second VLC won't help.
+		return fmt.Errorf("VLC is not accepting commands yet: %s", err)
+	}
+
+	// Input options go after the input they belong to
+	options = append(options, subtitle_options(files[0])...)
+	for _, file := range files[1:] {
+		options = append(append(options, file), subtitle_options(file)...)
+	}
+	return vlc.start(files[0], options...)
+}
+
+// subtitle_options returns the input option that makes VLC show the subtitle
+// of file, if it has one.
+func subtitle_options(file string) []string {
+	if subs := backend.PreferredSubtitle(file); subs != "" {
+		return []string{":sub-file=" + subs}
+	}
+	return nil
+}
+
+// rc_command returns an add or enqueue command for file and its input
+// options. VLC splits the command on whitespace outside of double quotes and
+// takes the quotes off, so the file and each option are quoted if need be.
+func rc_command(command, file string, options []string) string {
+	items := []string{command, rc_quote(file)}
+	for _, option := range options {
+		items = append(items, rc_quote(option))
+	}
+	return strings.Join(items, " ")
+}
+
+// rc_quote puts item in double quotes if it has whitespace or quotes in it,
+// with the double quotes in it escaped by a backslash.
+func rc_quote(item string) string {
+	if !strings.ContainsAny(item, " \t\"'") {
+		return item
+	}
+	return `"` + strings.Replace(item, `"`, `\"`, -1) + `"`
+}
+
+// PlayerPlaylist returns the titles of the entries in the playlist. The RC
+// interface doesn't know their paths.
+func (vlc *VLC) PlayerPlaylist() (titles []string, err error) {
+	rc, err := vlc.RC()
+	if err != nil {
+		return
+	}
+	lines, err := rc.Exec("playlist")
+	if err != nil {
+		return
+	}
+
+	// | 1 - Playlist
+	// |   4 - Show.S01E01.mkv (00:42:00) [played 1 time]
+	// | 2 - Media Library
+	node_indent := -1
+	in_playlist := false
+	for _, line := range lines {
+		matches := vlc_playlist_item_re.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		indent := len(matches[1])
+		if node_indent < 0 || indent <= node_indent {
+			node_indent = indent
+			in_playlist = strings.HasPrefix(matches[2], "Playlist")
+			continue
+		}
+		if in_playlist {
+			titles = append(titles, vlc_playlist_suffix_re.ReplaceAllString(matches[2], ""))
+		}
+	}
+	return
+}
+
+func (vlc *VLC) CanStartAt() bool {
+	return true
+}
+
+func (vlc *VLC) PlayFileAt(file string, start int) error {
+	return vlc.play("add", []string{file}, fmt.Sprintf(":start-time=%d", start))
+}
+
+func (vlc *VLC) PlaybackStatus() (status PlaybackStatus, err error) {
+	rc, err := vlc.RC()
+	if err == ErrNoSessionVLC {
+		return status, nil
+	} else if err != nil {
+		return
+	}
+
+	rc_status, err := rc.Status()
+	if err != nil || rc_status.Input == "" || rc_status.State == "stopped" {
+		return
+	}
+	status.File = mrl_to_path(rc_status.Input)
+	status.Playing = rc_status.State == "playing"
+	if status.Position, err = rc.GetTime(); err != nil {
+		return
+	}
+	status.Length, err = rc.GetLength()
+	return
+}
+
+// mrl_to_path turns the file:// URLs VLC reports inputs as back into paths.
+func mrl_to_path(mrl string) string {
+	u, err := url.Parse(mrl)
+	if err != nil || u.Scheme != "file" {
+		return mrl
+	}
+	path := u.Path
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:] // /C:/... on windows
+	}
+	return filepath.FromSlash(path)
+}
+
+// RC returns a client for the VLC started by this session, or for any VLC
+// listening on the configured endpoint if attaching is allowed.
+func (vlc *VLC) RC() (*RCClient, error) {
+	vlc.lock.Lock()
+	defer vlc.lock.Unlock()
+
+	if vlc.running {
+		return vlc.rc, nil
+	} else if vlc.attach_rc != nil {
+		return vlc.attach_rc, nil
+	}
+	return nil, ErrNoSessionVLC
+}
+
+func (vlc *VLC) is_running() bool {
+	vlc.lock.Lock()
+	defer vlc.lock.Unlock()
+	return vlc.running
+}
+
+// start starts a VLC owned by this session, playing file with the given
+// input options. More inputs, each followed by its options, may come after.
+func (vlc *VLC) start(file string, options ...string) error {
+	vlc.lock.Lock()
+	defer vlc.lock.Unlock()
+
+	network, address, listener, err := vlc.endpoint.claim()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--extraintf", "rc", "--rc-host", address}
+	if network == "unix" {
+		args = []string{"--extraintf", "rc", "--rc-unix", address}
+	}
+	command := exec.Command(vlc.executable, append(append(args, file), options...)...)
+	if listener != nil {
+		listener.Close() // Held until now so that nobody else takes the port
+	}
+	process, err := GlobalSupervisor.Start(command)
+	if err != nil {
+		vlc.endpoint.release(address)
+		return err
+	}
+
+	if vlc.rc != nil {
+		vlc.rc.Close()
+	}
+	vlc.rc = NewRCClient(network, address)
+	vlc.owned = process
+	vlc.running = true
+
+	go func() {
+		<-process.Done()
+		vlc.endpoint.release(address)
+		vlc.lock.Lock()
+		if vlc.owned == process {
+			vlc.running = false
+			vlc.rc.Close()
+		}
+		vlc.lock.Unlock()
+	}()
+
+	return nil
+}
+
+// claim picks the address a newly started VLC should listen on, making sure
+// that nobody else is already listening there. A configured TCP port that is
+// taken is replaced with a random one rather than risking that our commands
+// end up in somebody else's VLC. The TCP port is returned with a listener
+// on it, which is to be closed right before VLC is started.
+func (ep RCEndpoint) claim() (network, address string, listener net.Listener, err error) {
+	if ep.Private {
+		dir, err := ioutil.TempDir("", "nextplz-vlc-")
+		if err != nil {
+			return "", "", nil, err
+		}
+		return "unix", filepath.Join(dir, "rc.sock"), nil, nil
+	}
+
+	if ep.Unix != "" {
+		if conn, err := net.Dial("unix", ep.Unix); err == nil {
+			conn.Close()
+			return "", "", nil, fmt.Errorf("RC socket %s is already in use by another process", ep.Unix)
+		}
+		os.Remove(ep.Unix) // Stale socket from a VLC that is gone
+		return "unix", ep.Unix, nil, nil
+	}
+
+	if ep.Port != 0 {
+		listener, err = net.Listen("tcp", net.JoinHostPort(ep.Host, strconv.Itoa(ep.Port)))
+	}
+	if ep.Port == 0 || err != nil {
+		listener, err = net.Listen("tcp", net.JoinHostPort(ep.Host, "0"))
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+	return "tcp", listener.Addr().String(), listener, nil
+}
+
+// release cleans up after a VLC that listened on address has exited.
+func (ep RCEndpoint) release(address string) {
+	if ep.Private {
+		os.RemoveAll(filepath.Dir(address))
+	}
+}
+
+// unix_sockets_work reports whether VLC can listen on a unix socket here.
+func unix_sockets_work() bool {
+	return runtime.GOOS != "windows"
+}
+
+func (vlc *VLC) Capabilities() Capability {
+	return CapPause | CapStop | CapNext | CapPrevious | CapSeek | CapVolume | CapFullscreen
+}
+
+func (vlc *VLC) Pause() error {
+	return vlc.exec("pause")
+}
+
+func (vlc *VLC) Stop() error {
+	return vlc.exec("stop")
+}
+
+func (vlc *VLC) Next() error {
+	return vlc.exec("next")
+}
+
+func (vlc *VLC) Previous() error {
+	return vlc.exec("prev")
+}
+
+// Seek is relative, but the RC interface only knows absolute positions.
+func (vlc *VLC) Seek(seconds int) error {
+	rc, err := vlc.RC()
+	if err != nil {
+		return err
+	}
+	at, err := rc.GetTime()
+	if err != nil {
+		return err
+	}
+	_, err = rc.Exec(fmt.Sprintf("seek %d", util.Max(at+seconds, 0)))
+	return err
+}
+
+// ChangeVolume raises or lowers the volume in VLC's own steps, one step per
+// started 5%.
+func (vlc *VLC) ChangeVolume(percent int) error {
+	if percent < 0 {
+		return vlc.exec(fmt.Sprintf("voldown %d", (-percent+4)/5))
+	}
+	return vlc.exec(fmt.Sprintf("volup %d", (percent+4)/5))
+}
+
+func (vlc *VLC) ToggleFullscreen() error {
+	return vlc.exec("fullscreen")
+}
+
+func (vlc *VLC) exec(cmd string) error {
+	rc, err := vlc.RC()
+	if err != nil {
+		return err
+	}
+	_, err = rc.Exec(cmd)
+	return err
+}
@@ -0,0 +1,422 @@
+package media_player
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	vlc_http_timeout = 2 * time.Second
+
+	// VLC's volume goes from 0 to 512, where 256 is 100%
+	vlc_http_full_volume = 256
+)
+
+// VLCHTTP controls a VLC started by this session through the HTTP (Lua web)
+// interface. Unlike the RC interface, it is password protected and reports
+// its state as JSON.
+type VLCHTTP struct {
+	executable string
+	endpoint   RCEndpoint
+	client     *http.Client
+
+	lock     sync.Mutex
+	address  string // host:port of the interface of the VLC owned by this session
+	password string
+	owned    *Process
+	running  bool
+}
+
+// vlc_http_status is the part of /requests/status.json that is used.
+type vlc_http_status struct {
+	State  string `json:"state"`
+	Time   int    `json:"time"`
+	Length int    `json:"length"`
+	Volume int    `json:"volume"`
+}
+
+// vlc_http_node is a node of /requests/playlist.json.
+type vlc_http_node struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	URI      string          `json:"uri"`
+	Current  string          `json:"current"`
+	Children []vlc_http_node `json:"children"`
+}
+
+func CreateVLCHTTP(endpoint RCEndpoint) (MediaPlayer, error) {
+	executable, err := exec.LookPath("vlc")
+	if err != nil {
+		return nil, err
+	}
+	return MediaPlayer(NewVLCHTTP(executable, endpoint)), nil
+}
+
+// NewVLCHTTP creates a VLCHTTP whose VLC listens on the host and port of
+// endpoint, or a random port if that one is taken.
+func NewVLCHTTP(executable string, endpoint RCEndpoint) *VLCHTTP {
+	endpoint.Unix = ""
+	endpoint.Private = false
+	return &VLCHTTP{
+		executable: executable,
+		endpoint:   endpoint,
+		client:     &http.Client{Timeout: vlc_http_timeout},
+	}
+}
+
+func (vlc *VLCHTTP) PlayFile(file string) error {
+	return vlc.play("in_play", []string{file})
+}
+
+// Enqueue adds file to the playlist without interrupting playback. An idle
+// VLC starts playing it.
+func (vlc *VLCHTTP) Enqueue(file string) error {
+	if status, err := vlc.status(); err == nil && status.State == "stopped" {
+		return vlc.PlayFile(file)
+	}
+	return vlc.play("in_enqueue", []string{file})
+}
+
+// PlayFiles plays the first of files and queues the rest after it.
+func (vlc *VLCHTTP) PlayFiles(files []string) error {
+	return vlc.play("in_play", files)
+}
+
+func (vlc *VLCHTTP) CanStartAt() bool {
+	return true
+}
+
+func (vlc *VLCHTTP) PlayFileAt(file string, start int) error {
+	return vlc.play("in_play", []string{file}, fmt.Sprintf(":start-time=%d", start))
+}
+
+// play runs an input command for the first of files and enqueues the rest,
+// each with its subtitle if it has one, starting VLC with all of them if this
+// session has no VLC running. options are input options of the first file.
+func (vlc *VLCHTTP) play(command string, files []string, options ...string) error {
+	options = append(options, subtitle_options(files[0])...)
+	if !vlc.is_running() {
+		for _, file := range files[1:] {
+			options = append(append(options, file), subtitle_options(file)...)
+		}
+		return vlc.start(files[0], options...)
+	}
+
+	for i, file := range files {
+		if i > 0 {
+			command, options = "in_enqueue", subtitle_options(file)
+		}
+		params := url.Values{"input": {path_to_mrl(file)}}
+		for _, option := range options {
+			params.Add("option", option)
+		}
+		err := vlc.Command(command, params)
+		if _, refused := err.(*VLCHTTPError); err != nil && !refused {
+			return fmt.Errorf("VLC is not accepting commands yet: %s", err)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PlayerPlaylist returns the paths of the entries in the playlist.
+func (vlc *VLCHTTP) PlayerPlaylist() (files []string, err error) {
+	playlist, err := vlc.playlist()
+	if err != nil {
+		return nil, err
+	}
+	for _, leaf := range playlist {
+		files = append(files, mrl_to_path(leaf.URI))
+	}
+	return files, nil
+}
+
+func (vlc *VLCHTTP) PlaybackStatus() (status PlaybackStatus, err error) {
+	if !vlc.is_running() {
+		return status, nil
+	}
+
+	http_status, err := vlc.status()
+	if err != nil || http_status.State == "stopped" {
+		return
+	}
+	playlist, err := vlc.playlist()
+	if err != nil {
+		return
+	}
+	for _, leaf := range playlist {
+		if leaf.Current != "" {
+			status.File = mrl_to_path(leaf.URI)
+		}
+	}
+	if status.File == "" {
+		return
+	}
+	status.Position = http_status.Time
+	status.Length = http_status.Length
+	status.Playing = http_status.State == "playing"
+	return
+}
+
+func (vlc *VLCHTTP) Capabilities() Capability {
+	return CapPause | CapStop | CapNext | CapPrevious | CapSeek | CapVolume | CapFullscreen
+}
+
+func (vlc *VLCHTTP) Pause() error {
+	return vlc.Command("pl_pause", nil)
+}
+
+func (vlc *VLCHTTP) Stop() error {
+	return vlc.Command("pl_stop", nil)
+}
+
+func (vlc *VLCHTTP) Next() error {
+	return vlc.Command("pl_next", nil)
+}
+
+func (vlc *VLCHTTP) Previous() error {
+	return vlc.Command("pl_previous", nil)
+}
+
+func (vlc *VLCHTTP) Seek(seconds int) error {
+	return vlc.Command("seek", url.Values{"val": {fmt.Sprintf("%+d", seconds)}})
+}
+
+func (vlc *VLCHTTP) ChangeVolume(percent int) error {
+	step := percent * vlc_http_full_volume / 100
+	return vlc.Command("volume", url.Values{"val": {fmt.Sprintf("%+d", step)}})
+}
+
+func (vlc *VLCHTTP) ToggleFullscreen() error {
+	return vlc.Command("fullscreen", nil)
+}
+
+// Command sends command with params to the VLC started by this session.
+func (vlc *VLCHTTP) Command(command string, params url.Values) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("command", command)
+	return vlc.get("/requests/status.json", params, nil)
+}
+
+func (vlc *VLCHTTP) status() (status vlc_http_status, err error) {
+	err = vlc.get("/requests/status.json", nil, &status)
+	return
+}
+
+// playlist returns the entries of the playlist, leaving out the media
+// library and the like.
+func (vlc *VLCHTTP) playlist() (leaves []vlc_http_node, err error) {
+	var root vlc_http_node
+	if err = vlc.get("/requests/playlist.json", nil, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Children) == 0 {
+		return nil, nil
+	}
+
+	var collect func(node vlc_http_node)
+	collect = func(node vlc_http_node) {
+		if node.Type == "leaf" {
+			leaves = append(leaves, node)
+		}
+		for _, child := range node.Children {
+			collect(child)
+		}
+	}
+	collect(root.Children[0]) // The playlist comes first
+	return leaves, nil
+}
+
+// VLCHTTPError is an error status returned by the HTTP interface of VLC, as
+// opposed to an error in the communication with it.
+type VLCHTTPError struct {
+	Path   string
+	Status string
+}
+
+func (err *VLCHTTPError) Error() string {
+	return fmt.Sprintf("VLC: %s: %s", err.Path, err.Status)
+}
+
+func (vlc *VLCHTTP) get(path string, params url.Values, result interface{}) (err error) {
+	// Only commands change anything, the rest is polling
+	query := params.Get("command") == ""
+	defer func() {
+		request := path
+		if len(params) > 0 {
+			request += "?" + params.Encode()
+		}
+		log_command("vlc http", request, query, nil, err)
+	}()
+
+	vlc.lock.Lock()
+	running, address, password := vlc.running, vlc.address, vlc.password
+	vlc.lock.Unlock()
+	if !running {
+		return ErrNoSessionVLC
+	}
+
+	request_url := url.URL{Scheme: "http", Host: address, Path: path, RawQuery: params.Encode()}
+	request, err := http.NewRequest("GET", request_url.String(), nil)
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth("", password)
+
+	response, err := vlc.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return &VLCHTTPError{path, response.Status}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func (vlc *VLCHTTP) is_running() bool {
+	vlc.lock.Lock()
+	defer vlc.lock.Unlock()
+	return vlc.running
+}
+
+// start starts a VLC owned by this session with the HTTP interface protected
+// by a newly generated password, playing file with the given input options.
+// More inputs, each followed by its options, may come after. The password is
+// handed over in a config file rather than on the command line, where any
+// user could read it.
+func (vlc *VLCHTTP) start(file string, options ...string) error {
+	vlc.lock.Lock()
+	defer vlc.lock.Unlock()
+
+	_, address, listener, err := vlc.endpoint.claim()
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	host, port, err := split_host_port(address)
+	if err != nil {
+		return err
+	}
+	password, err := generate_password()
+	if err != nil {
+		return err
+	}
+
+	config, err := write_temp_vlcrc(password)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--config", config, "--extraintf", "http", "--http-host", host, "--http-port", strconv.Itoa(port), file}
+	command := exec.Command(vlc.executable, append(args, options...)...)
+	listener.Close() // Held until now so that nobody else takes the port
+	process, err := GlobalSupervisor.Start(command)
+	if err != nil {
+		os.Remove(config)
+		return err
+	}
+
+	vlc.address = address
+	vlc.password = password
+	vlc.owned = process
+	vlc.running = true
+
+	go func() {
+		<-process.Done()
+		os.Remove(config)
+		vlc.lock.Lock()
+		if vlc.owned == process {
+			vlc.running = false
+		}
+		vlc.lock.Unlock()
+	}()
+
+	return nil
+}
+
+// write_temp_vlcrc writes a VLC config file, readable only by the user, that
+// sets the password of the HTTP interface. As VLC reads only the one config
+// file, the settings of the user's own vlcrc are copied into it first.
+func write_temp_vlcrc(password string) (string, error) {
+	config, err := ioutil.TempFile("", "nextplz-vlcrc-*")
+	if err != nil {
+		return "", err
+	}
+	defer config.Close()
+
+	if user_config, err := ioutil.ReadFile(user_vlcrc_path()); err == nil {
+		config.Write(user_config)
+	}
+	// Later lines win, and section names are ignored
+	if _, err = fmt.Fprintf(config, "\nhttp-password=%s\n", password); err != nil {
+		os.Remove(config.Name())
+		return "", err
+	}
+	return config.Name(), nil
+}
+
+// user_vlcrc_path returns where VLC keeps its config file for the user.
+func user_vlcrc_path() string {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Preferences", "org.videolan.vlc", "vlcrc")
+	case "windows":
+		return filepath.Join(os.Getenv("APPDATA"), "vlc", "vlcrc")
+	}
+	if config_home := os.Getenv("XDG_CONFIG_HOME"); config_home != "" {
+		return filepath.Join(config_home, "vlc", "vlcrc")
+	}
+	return filepath.Join(home, ".config", "vlc", "vlcrc")
+}
+
+func split_host_port(address string) (host string, port int, err error) {
+	host, port_str, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err = strconv.Atoi(port_str)
+	return
+}
+
+func generate_password() (string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// path_to_mrl turns a path into the file:// URL VLC expects for inputs.
+func path_to_mrl(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	path := filepath.ToSlash(file)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // C:/... on windows
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -0,0 +1,250 @@
+package media_player
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	vlc_rc_timeout = 2 * time.Second
+	vlc_rc_prompt  = "> "
+)
+
+var vlc_rc_error_prefixes = []string{
+	"Unknown command",
+	"Error in",
+	"Error:",
+	"Unknown key",
+}
+
+// Commands that only ask VLC about something
+var vlc_rc_queries = map[string]bool{
+	"status":     true,
+	"is_playing": true,
+	"get_time":   true,
+	"get_length": true,
+	"get_title":  true,
+	"playlist":   true,
+}
+
+// RCError is an error reported by VLC in reply to an RC command.
+type RCError struct {
+	Command string
+	Message string
+}
+
+func (err *RCError) Error() string {
+	return fmt.Sprintf("vlc: %s: %s", err.Command, err.Message)
+}
+
+// RCStatus is the parsed reply of the RC status command.
+type RCStatus struct {
+	Input  string
+	Volume int
+	State  string
+}
+
+// RCClient talks to the remote control interface of VLC (--extraintf rc)
+// over a single persistent connection. The connection is opened on first use
+// and reopened if VLC has gone away in between commands.
+type RCClient struct {
+	Network string
+	Address string
+	Timeout time.Duration
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewRCClient(network, address string) *RCClient {
+	return &RCClient{Network: network, Address: address, Timeout: vlc_rc_timeout}
+}
+
+// Exec runs cmd and returns the lines VLC replied with, excluding the prompt
+// and asynchronous status change notifications.
+func (rc *RCClient) Exec(cmd string) ([]string, error) {
+	if strings.ContainsAny(cmd, "\r\n") {
+		return nil, fmt.Errorf("vlc: newline in RC command %q", cmd)
+	}
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	had_conn := rc.conn != nil
+	lines, err := rc.exec(cmd)
+	if err == io.EOF && had_conn {
+		// The connection was stale, VLC never saw the command.
+		lines, err = rc.exec(cmd)
+	}
+	log_command("vlc rc", cmd, vlc_rc_queries[strings.SplitN(cmd, " ", 2)[0]], lines, err)
+	return lines, err
+}
+
+func (rc *RCClient) Close() error {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return rc.disconnect()
+}
+
+func (rc *RCClient) IsPlaying() (bool, error) {
+	value, err := rc.exec_single("is_playing")
+	return value == "1", err
+}
+
+// GetTime returns the position in the current input in seconds.
+func (rc *RCClient) GetTime() (int, error) {
+	return rc.exec_int("get_time")
+}
+
+// GetLength returns the length of the current input in seconds.
+func (rc *RCClient) GetLength() (int, error) {
+	return rc.exec_int("get_length")
+}
+
+func (rc *RCClient) GetTitle() (string, error) {
+	return rc.exec_single("get_title")
+}
+
+func (rc *RCClient) Status() (status RCStatus, err error) {
+	lines, err := rc.Exec("status")
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "( "), " )")
+		switch {
+		case strings.HasPrefix(line, "new input: "):
+			status.Input = strings.TrimPrefix(line, "new input: ")
+		case strings.HasPrefix(line, "audio volume: "):
+			status.Volume, _ = strconv.Atoi(strings.TrimPrefix(line, "audio volume: "))
+		case strings.HasPrefix(line, "state "):
+			status.State = strings.TrimPrefix(line, "state ")
+		}
+	}
+	return
+}
+
+func (rc *RCClient) exec_single(cmd string) (string, error) {
+	lines, err := rc.Exec(cmd)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return lines[len(lines)-1], nil
+}
+
+func (rc *RCClient) exec_int(cmd string) (int, error) {
+	value, err := rc.exec_single(cmd)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return 0, nil // No current input
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &RCError{cmd, fmt.Sprintf("unexpected reply %q", value)}
+	}
+	return i, nil
+}
+
+func (rc *RCClient) exec(cmd string) ([]string, error) {
+	if rc.conn == nil {
+		if err := rc.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	rc.conn.SetDeadline(time.Now().Add(rc.Timeout))
+	_, err := fmt.Fprintf(rc.conn, "%s\n", cmd)
+	if err != nil {
+		rc.disconnect()
+		return nil, err
+	}
+
+	lines, err := rc.read_reply()
+	if err != nil {
+		rc.disconnect()
+		return nil, err
+	}
+
+	for _, line := range lines {
+		for _, prefix := range vlc_rc_error_prefixes {
+			if strings.HasPrefix(line, prefix) {
+				return lines, &RCError{cmd, line}
+			}
+		}
+	}
+	return lines, nil
+}
+
+func (rc *RCClient) connect() error {
+	conn, err := net.DialTimeout(rc.Network, rc.Address, rc.Timeout)
+	if err != nil {
+		return err
+	}
+	rc.conn = conn
+	rc.reader = bufio.NewReader(conn)
+
+	// Swallow the greeting
+	rc.conn.SetDeadline(time.Now().Add(rc.Timeout))
+	if _, err = rc.read_reply(); err != nil {
+		rc.disconnect()
+		return err
+	}
+	return nil
+}
+
+func (rc *RCClient) disconnect() error {
+	if rc.conn == nil {
+		return nil
+	}
+	err := rc.conn.Close()
+	rc.conn = nil
+	rc.reader = nil
+	return err
+}
+
+// read_reply reads everything up to the next prompt. VLC doesn't terminate
+// the prompt with a newline, so the reply is read byte by byte.
+func (rc *RCClient) read_reply() ([]string, error) {
+	var buf bytes.Buffer
+	for {
+		b, err := rc.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && buf.Len() > 0 {
+				err = errors.New("vlc: connection closed mid reply")
+			}
+			return nil, err
+		}
+		buf.WriteByte(b)
+
+		data := buf.Bytes()
+		if bytes.HasSuffix(data, []byte(vlc_rc_prompt)) &&
+			(len(data) == len(vlc_rc_prompt) || data[len(data)-len(vlc_rc_prompt)-1] == '\n') {
+			return split_rc_reply(data[:len(data)-len(vlc_rc_prompt)]), nil
+		}
+	}
+}
+
+func split_rc_reply(data []byte) (lines []string) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "status change:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return
+}
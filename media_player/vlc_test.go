@@ -0,0 +1,192 @@
+package media_player_test
+
+import (
+	"github.com/chrigrah/nextplz/backend"
+	"github.com/chrigrah/nextplz/media_player"
+	"github.com/chrigrah/nextplz/media_player/playertest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	backend.VideoExtensions = []string{".avi", ".mkv", ".mpg", ".wmv"} // The default of -extensions
+}
+
+// write_files creates empty files in dir.
+func write_files(t *testing.T, dir string, files ...string) {
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// attached_vlc returns a VLC attached to a new RCServer, with an executable
+// that must not be started.
+func attached_vlc(t *testing.T) (*media_player.VLC, *playertest.RCServer) {
+	server, err := playertest.NewRCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return media_player.NewVLC("/nonexistent/vlc", server.Endpoint()), server
+}
+
+func check_commands(t *testing.T, server *playertest.RCServer, want ...string) {
+	t.Helper()
+	if commands := server.Commands(); !reflect.DeepEqual(commands, want) {
+		t.Errorf("Commands = %q, want %q", commands, want)
+	}
+}
+
+func TestVLCPlaysOverRC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nextplz-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write_files(t, dir, "Show.S01E01.mkv", "Show.S01E01.srt", "Show.S01E02.mkv", "Show.S01E03.mkv")
+	e01, e02, e03 := filepath.Join(dir, "Show.S01E01.mkv"), filepath.Join(dir, "Show.S01E02.mkv"), filepath.Join(dir, "Show.S01E03.mkv")
+
+	vlc, server := attached_vlc(t)
+	defer server.Close()
+
+	if err := vlc.PlayFiles([]string{e01, e02}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vlc.Enqueue(e03); err != nil {
+		t.Fatal(err)
+	}
+	if err := vlc.TryQueue(e03); err != nil {
+		t.Fatal(err)
+	}
+	check_commands(t, server,
+		"add "+e01+" :sub-file="+filepath.Join(dir, "Show.S01E01.srt"),
+		"enqueue "+e02,
+		"enqueue "+e03,
+		"add "+e03)
+
+	status, err := vlc.PlaybackStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.File != e03 || !status.Playing {
+		t.Errorf("PlaybackStatus() = %+v, want %s playing", status, e03)
+	}
+	titles, err := vlc.PlayerPlaylist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Show.S01E01.mkv", "Show.S01E02.mkv", "Show.S01E03.mkv", "Show.S01E03.mkv"}
+	if !reflect.DeepEqual(titles, want) {
+		t.Errorf("PlayerPlaylist() = %q, want %q", titles, want)
+	}
+}
+
+func TestVLCQuotesPathsWithWhitespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nextplz test ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write_files(t, dir, "A Movie.mkv", "Subs/A Movie.srt", "Extras.mkv")
+	movie, extras := filepath.Join(dir, "A Movie.mkv"), filepath.Join(dir, "Extras.mkv")
+
+	vlc, server := attached_vlc(t)
+	defer server.Close()
+
+	if err := vlc.PlayFiles([]string{movie, extras}); err != nil {
+		t.Fatal(err)
+	}
+	check_commands(t, server,
+		`add "`+movie+`" ":sub-file=`+filepath.Join(dir, "Subs", "A Movie.srt")+`"`,
+		`enqueue "`+extras+`"`)
+
+	status, err := vlc.PlaybackStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.File != movie {
+		t.Errorf("PlaybackStatus().File = %q, want %q", status.File, movie)
+	}
+}
+
+func TestVLCReturnsRefusedCommands(t *testing.T) {
+	vlc, server := attached_vlc(t)
+	defer server.Close()
+
+	server.SetReply("add", "Unknown command `add'. Type `help' for help.")
+	err := vlc.PlayFile("/videos/Movie.mkv")
+	if _, refused := err.(*media_player.RCError); !refused {
+		t.Errorf("PlayFile() returned %v, want an *RCError", err)
+	}
+}
+
+func TestVLCTryQueueNeedsAVLC(t *testing.T) {
+	vlc := media_player.NewVLC("/nonexistent/vlc", media_player.RCEndpoint{Host: "127.0.0.1"})
+	if err := vlc.TryQueue("/videos/Movie.mkv"); err != media_player.ErrNoSessionVLC {
+		t.Errorf("TryQueue() without a VLC returned %v, want %v", err, media_player.ErrNoSessionVLC)
+	}
+}
+
+func TestVLCStartsWhenNotRunning(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The fake VLC is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "nextplz-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake VLC writes its arguments to a file, one per line
+	args_path := filepath.Join(dir, "args")
+	executable := filepath.Join(dir, "vlc")
+	script := "#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\"; done > " + args_path + ".tmp\nmv " + args_path + ".tmp " + args_path + "\n"
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write_files(t, dir, "videos/Show.S01E01.mkv", "videos/Show.S01E01.srt", "videos/Show.S01E02.mkv")
+	e01, e02 := filepath.Join(dir, "videos", "Show.S01E01.mkv"), filepath.Join(dir, "videos", "Show.S01E02.mkv")
+
+	vlc := media_player.NewVLC(executable, media_player.RCEndpoint{Private: true})
+	if err := vlc.PlayFiles([]string{e01, e02}); err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if data, err = ioutil.ReadFile(args_path); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal("VLC wasn't started")
+	}
+	args := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(args) != 7 || args[0] != "--extraintf" || args[1] != "rc" || args[2] != "--rc-unix" {
+		t.Fatalf("VLC was started with %q", args)
+	}
+	want := []string{e01, ":sub-file=" + filepath.Join(dir, "videos", "Show.S01E01.srt"), e02}
+	if !reflect.DeepEqual(args[4:], want) {
+		t.Errorf("VLC was started with the inputs %q, want %q", args[4:], want)
+	}
+
+	// The socket is in a directory of its own, which goes with VLC
+	socket_dir := filepath.Dir(args[3])
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if _, err = os.Stat(socket_dir); os.IsNotExist(err) {
+			return
+		}
+	}
+	t.Errorf("The directory of the RC socket, %s, is left after VLC exited", socket_dir)
+}
@@ -0,0 +1,16 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ConfigDir returns the directory nextplz keeps its configuration and state
+// in, ~/.nextplz. It is not created until something is written to it.
+func ConfigDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE") // windows
+	}
+	return filepath.Join(home, ".nextplz")
+}
@@ -0,0 +1,14 @@
+package util
+
+import (
+	"fmt"
+)
+
+// FormatSeconds formats a duration in seconds as m:ss, or h:mm:ss if it is
+// an hour or longer.
+func FormatSeconds(seconds int) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, (seconds/60)%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}